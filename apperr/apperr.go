@@ -0,0 +1,160 @@
+// Package apperr contains the errors returned from resolvers. Every error has a
+// stable code that is sent to the client in extensions.code, while the cause is
+// only logged on the server.
+package apperr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/jackc/pgconn"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+type Code string
+
+const (
+	Unauthorized             Code = "UNAUTHORIZED"
+	Forbidden                Code = "FORBIDDEN"
+	UserInput                Code = "USER_INPUT_ERROR"
+	Authentication           Code = "AUTHENTICATION_ERROR"
+	NotFound                 Code = "NOT_FOUND"
+	InvalidImage             Code = "INVALID_IMAGE"
+	ExistingEmail            Code = "EXISTING_EMAIL"
+	ExistingUsername         Code = "EXISTING_USERNAME"
+	ExpiredEmailVerification Code = "EXPIRED_EMAIL_VERIFICATION"
+	InvalidEmailVerification Code = "INVALID_EMAIL_VERIFICATION"
+	ExpiredPasswordReset     Code = "EXPIRED_PASSWORD_RESET"
+	InvalidPasswordReset     Code = "INVALID_PASSWORD_RESET"
+	TooManyRequests          Code = "TOO_MANY_REQUESTS"
+	DuplicateReview          Code = "DUPLICATE_REVIEW"
+	DuplicateChip            Code = "DUPLICATE_CHIP"
+	DuplicateBrand           Code = "DUPLICATE_BRAND"
+	AlreadyLiked             Code = "ALREADY_LIKED"
+	AlreadyFollowing         Code = "ALREADY_FOLLOWING"
+	UnknownChip              Code = "UNKNOWN_CHIP"
+	UnknownBrand             Code = "UNKNOWN_BRAND"
+	UnknownReview            Code = "UNKNOWN_REVIEW"
+	UnknownUser              Code = "UNKNOWN_USER"
+	InvalidBarcode           Code = "INVALID_BARCODE"
+	DuplicateBarcode         Code = "DUPLICATE_BARCODE"
+	UnknownComment           Code = "UNKNOWN_COMMENT"
+	UnknownReport            Code = "UNKNOWN_REPORT"
+	ReportResolved           Code = "REPORT_RESOLVED"
+	Banned                   Code = "BANNED"
+	AlreadyHidden            Code = "ALREADY_HIDDEN"
+	LastAdmin                Code = "LAST_ADMIN"
+	Internal                 Code = "INTERNAL_SERVER_ERROR"
+)
+
+// Error is an error with a code and a message that are safe to show to the client
+type Error struct {
+	Code    Code
+	Message string
+	// Err is the underlying cause, it is logged but never sent to the client
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New creates an error that is expected, e.g. invalid input
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap creates an error with a cause that should be logged
+func Wrap(err error, code Code, message string) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// InternalError creates an error for failures that are not the client's fault.
+// The description is logged together with the cause.
+func InternalError(err error, description string) *Error {
+	if err == nil {
+		err = errors.New(description)
+	} else {
+		err = fmt.Errorf("%s: %w", description, err)
+	}
+	return &Error{Code: Internal, Message: "Internal server error", Err: err}
+}
+
+// Constraint violations that are caused by the client, keyed by constraint name
+var constraints = map[string]*Error{
+	"users_email_key":              New(ExistingEmail, "Email already exists"),
+	"users_username_key":           New(ExistingUsername, "Username already exists"),
+	"reviews_chips_id_user_id_key": New(DuplicateReview, "The chip has already been reviewed"),
+	"reviews_chips_id_fkey":        New(UnknownChip, "Chip does not exist"),
+	"chips_brand_id_slug_key":      New(DuplicateChip, "A chip with that slug already exists"),
+	"chips_brand_id_fkey":          New(UnknownBrand, "Brand does not exist"),
+	"brands_pkey":                  New(DuplicateBrand, "Brand already exists"),
+	"likes_pkey":                   New(AlreadyLiked, "The review has already been liked"),
+	"likes_review_id_fkey":         New(UnknownReview, "Review does not exist"),
+	"follows_pkey":                 New(AlreadyFollowing, "The user is already followed"),
+	"follows_follows_user_id_fkey": New(UnknownUser, "User does not exist"),
+	"follows_check":                New(UserInput, "Users can not follow themselves"),
+	"barcodes_pkey":                New(DuplicateBarcode, "The barcode already belongs to a chip"),
+	"comments_review_id_fkey":      New(UnknownReview, "Review does not exist"),
+}
+
+// FromDB converts an error from a query. Unique, foreign key and check violations
+// get a code describing the conflict, everything else is internal.
+func FromDB(err error, description string) *Error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505", "23503", "23514": // unique_violation, foreign_key_violation, check_violation
+			if known, ok := constraints[pgErr.ConstraintName]; ok {
+				return Wrap(err, known.Code, known.Message)
+			}
+			return Wrap(err, UserInput, description)
+		}
+	}
+	return InternalError(err, description)
+}
+
+// Presenter is a gqlgen ErrorPresenter that logs the cause of errors and only
+// sends the code and message to the client
+func Presenter(ctx context.Context, err error) *gqlerror.Error {
+	path := graphql.GetPath(ctx)
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		if appErr.Err != nil {
+			log.Printf("graphql error: code=%s path=%s message=%q cause=%q", appErr.Code, path, appErr.Message, appErr.Err)
+		}
+		return &gqlerror.Error{
+			Message:    appErr.Message,
+			Path:       path,
+			Extensions: map[string]interface{}{"code": appErr.Code},
+		}
+	}
+	// Errors from gqlgen, e.g. invalid arguments, are already safe to show
+	var gqlErr *gqlerror.Error
+	if errors.As(err, &gqlErr) {
+		return gqlErr
+	}
+	log.Printf("graphql error: code=%s path=%s cause=%q", Internal, path, err)
+	return &gqlerror.Error{
+		Message:    "Internal server error",
+		Path:       path,
+		Extensions: map[string]interface{}{"code": Internal},
+	}
+}
+
+// Recover is a gqlgen RecoverFunc that logs panics in resolvers with a stack trace
+func Recover(ctx context.Context, err interface{}) error {
+	log.Printf("graphql panic: path=%s cause=%q\n%s", graphql.GetPath(ctx), fmt.Sprint(err), debug.Stack())
+	return New(Internal, "Internal server error")
+}
@@ -2,13 +2,19 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/c-wiren/snackstoppen-backend/pubsub"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 // A private key for context that only this package can access. This is important
@@ -22,14 +28,26 @@ type contextKey struct {
 
 // A stand-in for our database backed user object
 type User struct {
-	ID   int
-	Role string
+	ID        int
+	Role      string
+	SessionID int
+
+	// expires is when the access token expires
+	expires time.Time
 }
 
-// Middleware decodes the share session cookie and packs the session into context
-func Middleware() func(http.Handler) http.Handler {
+var errInvalidToken = errors.New("Invalid token")
+
+// ErrBanned is returned for users that are banned
+var ErrBanned = errors.New("User is banned")
+
+// Middleware decodes the share session cookie and packs the session into context.
+// The role and ban of the user and the session are read from db, so that
+// changes and revoked sessions apply before the access token expires.
+func Middleware(db *pgxpool.Pool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), clientCtxKey, clientFromRequest(r)))
 			rawToken := r.Header.Get("authorization")
 			if rawToken == "" {
 				next.ServeHTTP(w, r)
@@ -40,61 +58,155 @@ func Middleware() func(http.Handler) http.Handler {
 				return
 			}
 
-			// Parse JWT
-			token, err := jwt.Parse(splitToken[1], func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-				}
-				return []byte(Secret), nil
-			})
-			if err != nil || !token.Valid {
-				http.Error(w, "{\"errors\":[{\"message\": \"Invalid token\",\"extensions\": {\"code\": \"AUTHENTICATION_ERROR\"}}]}", http.StatusOK)
-				return
+			user, err := parseToken(splitToken[1])
+			if err == nil {
+				err = checkUser(r.Context(), db, user)
 			}
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				panic(fmt.Errorf("token claims error"))
+			if err == ErrBanned {
+				http.Error(w, "{\"errors\":[{\"message\": \"User is banned\",\"extensions\": {\"code\": \"BANNED\"}}]}", http.StatusOK)
+				return
 			}
-			rawID, _ := claims["id"].(float64)
-			id := int(rawID)
-			if err != nil {
+			if err == errInvalidToken {
 				http.Error(w, "{\"errors\":[{\"message\": \"Invalid token\",\"extensions\": {\"code\": \"AUTHENTICATION_ERROR\"}}]}", http.StatusOK)
 				return
 			}
-			role, _ := claims["role"].(string)
-
-			// Check if refresh token
-			if claims["logout"] != nil {
-				http.Error(w, "{\"errors\":[{\"message\": \"Invalid token\",\"extensions\": {\"code\": \"AUTHENTICATION_ERROR\"}}]}", http.StatusOK)
+			if err != nil {
+				log.Printf("auth: user query failed: %v", err)
+				http.Error(w, "{\"errors\":[{\"message\": \"Internal server error\",\"extensions\": {\"code\": \"INTERNAL_SERVER_ERROR\"}}]}", http.StatusOK)
 				return
 			}
 
 			// put it in context
-			user := User{ID: id, Role: role}
-			ctx := context.WithValue(r.Context(), userCtxKey, &user)
+			ctx := context.WithValue(r.Context(), userCtxKey, user)
 			r = r.WithContext(ctx)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// WebsocketInit authenticates websocket connections with the same access
+// token as Middleware, sent as authorization in the connection init payload.
+// The connection is closed when the token expires or the role or ban of the
+// user changes, so that the client reconnects and is checked again.
+func WebsocketInit(db *pgxpool.Pool, broker *pubsub.Broker) transport.WebsocketInitFunc {
+	return func(ctx context.Context, payload transport.InitPayload) (context.Context, error) {
+		rawToken := payload.Authorization()
+		if rawToken == "" {
+			return ctx, nil
+		}
+		user, err := parseToken(strings.TrimPrefix(rawToken, "Bearer "))
+		if err != nil {
+			return nil, err
+		}
+		err = checkUser(ctx, db, user)
+		if err != nil && err != ErrBanned && err != errInvalidToken {
+			log.Printf("auth: user query failed: %v", err)
+			return nil, errors.New("Internal server error")
+		}
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(withUser(ctx, user))
+		changes := broker.Subscribe(ctx, pubsub.UsersTopic(user.ID))
+		go func() {
+			// Returns on the first change or when the connection ends
+			<-changes
+			cancel()
+		}()
+		return ctx, nil
+	}
+}
+
+// withUser puts the user in the context of a websocket connection, which is
+// cancelled when the access token expires
+func withUser(ctx context.Context, user *User) context.Context {
+	ctx = context.WithValue(ctx, userCtxKey, user)
+	if !user.expires.IsZero() {
+		// Released when the connection ends or the token expires
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, user.expires)
+		go func() {
+			<-ctx.Done()
+			cancel()
+		}()
+	}
+	return ctx
+}
+
+// checkUser reads the current role of the user and fails if they are banned
+// or deleted, or the session of the token has been revoked
+func checkUser(ctx context.Context, db *pgxpool.Pool, user *User) error {
+	var banned bool
+	var err error
+	if user.SessionID == 0 {
+		// Access tokens from before sessions are accepted until they expire,
+		// see UpgradeLegacySession
+		err = db.QueryRow(ctx, `SELECT role, COALESCE(banned_until > NOW(), false)
+		FROM users WHERE id=$1`, user.ID).Scan(&user.Role, &banned)
+	} else {
+		err = db.QueryRow(ctx, `SELECT users.role, COALESCE(users.banned_until > NOW(), false)
+		FROM users INNER JOIN sessions ON sessions.user_id=users.id
+		WHERE users.id=$1 AND sessions.id=$2`, user.ID, user.SessionID).Scan(&user.Role, &banned)
+	}
+	if err == pgx.ErrNoRows {
+		return errInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrBanned
+	}
+	return nil
+}
+
+// parseToken validates an access token
+func parseToken(rawToken string) (*User, error) {
+	token, err := jwt.Parse(rawToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(Secret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		panic(fmt.Errorf("token claims error"))
+	}
+
+	// Check if refresh token
+	if claims["typ"] == "refresh" || claims["logout"] != nil {
+		return nil, errInvalidToken
+	}
+	rawID, _ := claims["id"].(float64)
+	role, _ := claims["role"].(string)
+	rawSession, _ := claims["session"].(float64)
+	user := &User{ID: int(rawID), Role: role, SessionID: int(rawSession)}
+	if exp, ok := claims["exp"].(float64); ok {
+		user.expires = time.Unix(int64(exp), 0)
+	}
+	return user, nil
+}
+
 // ForContext finds the user from the context. REQUIRES Middleware to have run.
 func ForContext(ctx context.Context) *User {
 	raw, _ := ctx.Value(userCtxKey).(*User)
 	return raw
 }
 
-func CreateAccessToken(user *model.CompleteUser) *string {
+func CreateAccessToken(user *model.CompleteUser, session *Session) *string {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		//"username":  user.Username,
 		//"firstname": user.Firstname,
 		//"lastname":  user.Lastname,
-		"id":   user.ID,
-		"role": user.Role,
+		"id":      user.ID,
+		"role":    user.Role,
+		"session": session.ID,
 		//"email":     user.Email,
 		//"image":     user.Image,
 		//"created":   user.Created,
-		//"logout":    user.Logout,
 		"exp": time.Now().Add(time.Minute * 30).Unix(),
 		"iat": time.Now().Unix(),
 	})
@@ -102,42 +214,34 @@ func CreateAccessToken(user *model.CompleteUser) *string {
 	return &accessToken
 }
 
-func CreateRefreshToken(user *model.CompleteUser) *string {
+func CreateRefreshToken(session *Session) *string {
 	token2 := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":     user.ID,
-		"logout": user.Logout,
-		"iat":    time.Now().Unix(),
+		"typ":     "refresh",
+		"id":      session.UserID,
+		"session": session.ID,
+		"token":   session.Token,
+		"iat":     time.Now().Unix(),
 	})
 	refreshToken, _ := token2.SignedString([]byte(Secret))
 	return &refreshToken
 }
 
-func CreateLoginResponse(user model.CompleteUser, includeRefreshToken bool) *model.LoginResponse {
+func CreateLoginResponse(user model.CompleteUser, session *Session) *model.LoginResponse {
 	exp := time.Now().Add(time.Minute * 30)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		//"username":  user.Username,
 		//"firstname": user.Firstname,
 		//"lastname":  user.Lastname,
-		"id":   user.ID,
-		"role": user.Role,
+		"id":      user.ID,
+		"role":    user.Role,
+		"session": session.ID,
 		//"email":     user.Email,
 		//"image":     user.Image,
 		//"created":   user.Created,
-		//"logout":    user.Logout,
 		"exp": exp.Unix(),
 		"iat": time.Now().Unix(),
 	})
 	accessToken, _ := token.SignedString([]byte(Secret))
-
-	var refreshToken string
-	if includeRefreshToken {
-		token2 := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"id":     user.ID,
-			"logout": user.Logout,
-			"iat":    time.Now().Unix(),
-		})
-		refreshToken, _ = token2.SignedString([]byte(Secret))
-	}
 	return &model.LoginResponse{User: &model.User{
 		ID:        user.ID,
 		Username:  user.Username,
@@ -146,6 +250,6 @@ func CreateLoginResponse(user model.CompleteUser, includeRefreshToken bool) *mod
 		Image:     user.Image,
 	},
 		Token:   accessToken,
-		Refresh: &refreshToken,
+		Refresh: CreateRefreshToken(session),
 		Expires: exp}
 }
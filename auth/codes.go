@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Purpose is what a verification code is for
+type Purpose string
+
+const (
+	// PasswordReset codes reset the password of a user
+	PasswordReset Purpose = "password_reset"
+	// EmailVerification codes prove that a new user owns the email
+	EmailVerification Purpose = "email_verification"
+)
+
+// How long a verification code can be used
+const codeLifetime = 10 * time.Minute
+
+// Incorrect codes accepted before a verification code expires
+const maxCodeAttempts = 5
+
+// Codes created within codeWindow are limited per email, so that an attacker
+// can not flood someone with emails or get more codes to guess, and per client
+// IP, so that one client can not try every email. Older codes are purged.
+const (
+	codeWindow       = time.Hour
+	maxCodesPerEmail = 3
+	maxCodesPerIP    = 10
+)
+
+// ErrCodeExpired is returned when a verification code is unknown, used,
+// expired or has too many incorrect attempts
+var ErrCodeExpired = errors.New("verification code expired")
+
+// ErrCodeInvalid is returned when a verification code is incorrect
+var ErrCodeInvalid = errors.New("verification code incorrect")
+
+// ErrCodeLimited is returned when too many codes have been created for the
+// email or from the client
+var ErrCodeLimited = errors.New("too many verification codes")
+
+// A Code is a verification code that was entered correctly
+type Code struct {
+	Email string
+	// UserID is nil for email verifications and resets of unknown emails
+	UserID *int
+}
+
+// CreateCode stores the hash of code and returns the opaque id that the client
+// sends back with the code, earlier codes of the email and purpose can no
+// longer be used. Only the server can check a code, unlike a signed token with
+// its hash that could be brute forced offline.
+func CreateCode(ctx context.Context, db *pgxpool.Pool, purpose Purpose, email string, userID *int, code string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), 10)
+	if err != nil {
+		return "", err
+	}
+	ip := ClientForContext(ctx).IP
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	// Concurrent requests for the same email are counted one at a time
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('verification_codes:' || $1 || ':' || $2))`, purpose, email)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM verification_codes WHERE created < $1`, time.Now().Add(-codeWindow))
+	if err != nil {
+		return "", err
+	}
+	var byEmail, byIP int
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FILTER (WHERE email=$2), COUNT(*) FILTER (WHERE ip=$3)
+	FROM verification_codes WHERE purpose=$1 AND (email=$2 OR ip=$3)`, purpose, email, ip).Scan(&byEmail, &byIP)
+	if err != nil {
+		return "", err
+	}
+	if byEmail >= maxCodesPerEmail || (ip != "" && byIP >= maxCodesPerIP) {
+		return "", ErrCodeLimited
+	}
+	_, err = tx.Exec(ctx, `UPDATE verification_codes SET used=NOW() WHERE purpose=$1 AND email=$2 AND used IS NULL`, purpose, email)
+	if err != nil {
+		return "", err
+	}
+	id := newSessionToken()
+	_, err = tx.Exec(ctx, `INSERT INTO verification_codes (id, purpose, user_id, email, ip, code_hash, expires)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`, id, purpose, userID, email, ip, string(hash), time.Now().Add(codeLifetime))
+	if err != nil {
+		return "", err
+	}
+	return id, tx.Commit(ctx)
+}
+
+// CheckCode counts an attempt at the code with the id and returns it if code
+// is correct. The attempt is counted outside of any transaction so that a
+// failed request can not undo it.
+func CheckCode(ctx context.Context, db *pgxpool.Pool, purpose Purpose, id string, code string) (*Code, error) {
+	var result Code
+	var hash string
+	err := db.QueryRow(ctx, `UPDATE verification_codes SET attempts = attempts + 1
+	WHERE id=$1 AND purpose=$2 AND used IS NULL AND expires > NOW() AND attempts < $3
+	RETURNING email, user_id, code_hash`, id, purpose, maxCodeAttempts).Scan(&result.Email, &result.UserID, &hash)
+	if err == pgx.ErrNoRows {
+		return nil, ErrCodeExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(code))
+	if err != nil {
+		return nil, ErrCodeInvalid
+	}
+	return &result, nil
+}
+
+// UseCode marks the code as used, it fails if it has already been used by a
+// concurrent request
+func UseCode(ctx context.Context, tx pgx.Tx, id string) error {
+	commandTag, err := tx.Exec(ctx, `UPDATE verification_codes SET used=NOW() WHERE id=$1 AND used IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrCodeExpired
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+)
+
+// rolePermissions are the permissions of every role, roles that are not
+// listed have none
+var rolePermissions = map[model.Role][]model.Permission{
+	model.RoleUser:      {},
+	model.RoleModerator: {model.PermissionModerateContent},
+	model.RoleAdmin:     model.AllPermission,
+}
+
+// Can reports whether the role of the user has a permission
+func (u *User) Can(perm model.Permission) bool {
+	for _, p := range rolePermissions[model.Role(strings.ToUpper(u.Role))] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var clientCtxKey = &contextKey{"client"}
+
+// ErrSessionReused is returned when a refresh token that has already been rotated is used again
+var ErrSessionReused = errors.New("refresh token reused")
+
+// ErrSessionNotFound is returned when the session has been revoked
+var ErrSessionNotFound = errors.New("session not found")
+
+// A Session is one logged in device, backed by a row in the sessions table
+type Session struct {
+	ID     int
+	UserID int
+	// Token identifies the only refresh token that is currently valid for the session
+	Token string
+}
+
+// Client describes the device that sent a request
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// ClientForContext finds the requesting client from the context. REQUIRES Middleware to have run.
+func ClientForContext(ctx context.Context) *Client {
+	raw, _ := ctx.Value(clientCtxKey).(*Client)
+	if raw == nil {
+		return &Client{}
+	}
+	return raw
+}
+
+// TrustedProxies are the proxies whose X-Forwarded-For header is used for the
+// client IP, otherwise any client could set its own
+var TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or IPs
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func clientFromRequest(r *http.Request) *Client {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	// Proxies append the address they received the request from, so the
+	// client is the last address that was not added by a trusted proxy
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" && trustedProxy(ip) {
+		addresses := strings.Split(forwarded, ",")
+		for i := len(addresses) - 1; i >= 0; i-- {
+			ip = strings.TrimSpace(addresses[i])
+			if !trustedProxy(ip) {
+				break
+			}
+		}
+	}
+	return &Client{UserAgent: r.UserAgent(), IP: ip}
+}
+
+func newSessionToken() string {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CreateSession stores a new session for the user and the requesting client
+func CreateSession(ctx context.Context, db *pgxpool.Pool, userID int, device *string) (*Session, error) {
+	client := ClientForContext(ctx)
+	session := Session{UserID: userID, Token: newSessionToken()}
+	err := db.QueryRow(ctx, `INSERT INTO sessions (user_id, token, device, user_agent, ip)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id`, userID, session.Token, device, client.UserAgent, client.IP).Scan(&session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSession replaces the refresh token of a session. If the presented token is
+// not the current one, it has been used before and the whole session is revoked.
+func RotateSession(ctx context.Context, db *pgxpool.Pool, id int, userID int, token string) (*Session, error) {
+	client := ClientForContext(ctx)
+	session := Session{ID: id, UserID: userID, Token: newSessionToken()}
+	commandTag, err := db.Exec(ctx, `UPDATE sessions
+	SET token=$1, last_used=NOW(), user_agent=$2, ip=$3
+	WHERE id=$4 AND user_id=$5 AND token=$6`, session.Token, client.UserAgent, client.IP, id, userID, token)
+	if err != nil {
+		return nil, err
+	}
+	if commandTag.RowsAffected() == 1 {
+		return &session, nil
+	}
+	// Revoke the session in case the token has been stolen
+	commandTag, err = db.Exec(ctx, `DELETE FROM sessions WHERE id=$1 AND user_id=$2`, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if commandTag.RowsAffected() == 1 {
+		return nil, ErrSessionReused
+	}
+	return nil, ErrSessionNotFound
+}
+
+// UpgradeLegacySession creates a session for a refresh token from before
+// sessions, which held the time the user last logged out everywhere. It fails
+// with ErrSessionNotFound if they have logged out since. Legacy tokens are
+// accepted for one release so that users are not all logged out at once.
+func UpgradeLegacySession(ctx context.Context, db *pgxpool.Pool, userID int, logout string) (*Session, error) {
+	issued, err := time.Parse(time.RFC3339, logout)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	var current time.Time
+	err = db.QueryRow(ctx, `SELECT logout FROM users WHERE id=$1`, userID).Scan(&current)
+	if err == pgx.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.Unix() != issued.Unix() {
+		return nil, ErrSessionNotFound
+	}
+	return CreateSession(ctx, db, userID, nil)
+}
@@ -1,3 +1,4 @@
 #!/bin/bash
-GOARCH=amd64 GOOS=linux go build -ldflags="-w -s" -o build/server *.go
+# WebP encoding uses cgo, link statically so the binary runs on alpine
+CGO_ENABLED=1 GOARCH=amd64 GOOS=linux go build -tags netgo,osusergo -ldflags='-w -s -extldflags "-static"' -o build/server *.go
 git subtree push --prefix build dokku main
@@ -0,0 +1,92 @@
+// Package dataloader batches the lookups that nested GraphQL fields make, so that
+// a list of reviews loads its users and chips with one query each instead of one
+// query per review.
+package dataloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How long a loader waits for more keys before fetching
+const wait = 2 * time.Millisecond
+
+// Maximum number of keys in one fetch
+const maxBatch = 500
+
+// FetchFunc loads the values for keys. Keys without a value are left out of the map.
+type FetchFunc func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error)
+
+// Loader batches and caches lookups by key. A loader lives for one request, so
+// the cache never serves stale data to another request.
+type Loader struct {
+	ctx   context.Context
+	fetch FetchFunc
+
+	mu    sync.Mutex
+	cache map[interface{}]*result
+	batch *batch
+}
+
+type result struct {
+	value interface{}
+	err   error
+	done  chan struct{}
+}
+
+type batch struct {
+	keys    []interface{}
+	results []*result
+	once    sync.Once
+}
+
+// NewLoader creates a loader that fetches with ctx, usually the request context
+func NewLoader(ctx context.Context, fetch FetchFunc) *Loader {
+	return &Loader{ctx: ctx, fetch: fetch, cache: map[interface{}]*result{}}
+}
+
+// Load returns the value for key, or nil if it does not exist
+func (l *Loader) Load(key interface{}) (interface{}, error) {
+	l.mu.Lock()
+	r, ok := l.cache[key]
+	if !ok {
+		r = &result{done: make(chan struct{})}
+		l.cache[key] = r
+		if l.batch == nil {
+			b := &batch{}
+			l.batch = b
+			time.AfterFunc(wait, func() { l.run(b) })
+		}
+		b := l.batch
+		b.keys = append(b.keys, key)
+		b.results = append(b.results, r)
+		if len(b.keys) >= maxBatch {
+			l.batch = nil
+			go l.run(b)
+		}
+	}
+	l.mu.Unlock()
+	<-r.done
+	return r.value, r.err
+}
+
+func (l *Loader) run(b *batch) {
+	b.once.Do(func() {
+		l.mu.Lock()
+		if l.batch == b {
+			l.batch = nil
+		}
+		l.mu.Unlock()
+
+		values, err := l.fetch(l.ctx, b.keys)
+		for i, r := range b.results {
+			if err != nil {
+				r.err = err
+			} else {
+				r.value = values[b.keys[i]]
+			}
+			close(r.done)
+		}
+	})
+}
@@ -0,0 +1,343 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var loadersCtxKey = &contextKey{"loaders"}
+
+type contextKey struct {
+	name string
+}
+
+// Loaders are the loaders for one request
+type Loaders struct {
+	chipByID          *Loader
+	brandByID         *Loader
+	userByID          *Loader
+	followersByUser   *Loader
+	barcodesByChip    *Loader
+	reviewByID        *Loader
+	revisionsByReview *Loader
+}
+
+// requestLoaders holds the loaders of a request until Refresh replaces them
+type requestLoaders struct {
+	ctx     context.Context
+	db      *pgxpool.Pool
+	loaders atomic.Pointer[Loaders]
+}
+
+// Middleware creates new loaders for every request
+func Middleware(db *pgxpool.Pool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			holder := &requestLoaders{ctx: r.Context(), db: db}
+			holder.loaders.Store(NewLoaders(r.Context(), db))
+			ctx := context.WithValue(r.Context(), loadersCtxKey, holder)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ErrNoMiddleware is returned by every loader of a context that Middleware
+// has not run for
+var ErrNoMiddleware = errors.New("dataloader: Middleware has not run")
+
+// For finds the loaders from the context. Without Middleware every load fails
+// with ErrNoMiddleware.
+func For(ctx context.Context) *Loaders {
+	holder, ok := ctx.Value(loadersCtxKey).(*requestLoaders)
+	if !ok {
+		return failingLoaders(ctx)
+	}
+	return holder.loaders.Load()
+}
+
+// failingLoaders returns loaders that fail with ErrNoMiddleware
+func failingLoaders(ctx context.Context) *Loaders {
+	fail := func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		return nil, ErrNoMiddleware
+	}
+	return &Loaders{
+		chipByID:          NewLoader(ctx, fail),
+		brandByID:         NewLoader(ctx, fail),
+		userByID:          NewLoader(ctx, fail),
+		followersByUser:   NewLoader(ctx, fail),
+		barcodesByChip:    NewLoader(ctx, fail),
+		reviewByID:        NewLoader(ctx, fail),
+		revisionsByReview: NewLoader(ctx, fail),
+	}
+}
+
+// Refresh replaces the loaders of the request with empty ones. A websocket
+// connection is one long request, so subscriptions refresh the loaders for
+// every event instead of serving stale data from an ever growing cache.
+func Refresh(ctx context.Context) {
+	holder, ok := ctx.Value(loadersCtxKey).(*requestLoaders)
+	if ok {
+		holder.loaders.Store(NewLoaders(holder.ctx, holder.db))
+	}
+}
+
+// NewLoaders creates loaders that query db with ctx
+func NewLoaders(ctx context.Context, db *pgxpool.Pool) *Loaders {
+	return &Loaders{
+		chipByID:          NewLoader(ctx, fetchChips(db)),
+		brandByID:         NewLoader(ctx, fetchBrands(db)),
+		userByID:          NewLoader(ctx, fetchUsers(db)),
+		followersByUser:   NewLoader(ctx, fetchFollowers(db)),
+		barcodesByChip:    NewLoader(ctx, fetchBarcodes(db)),
+		reviewByID:        NewLoader(ctx, fetchReviews(db)),
+		revisionsByReview: NewLoader(ctx, fetchRevisions(db)),
+	}
+}
+
+// Chip returns the chip with id, or nil if it does not exist
+func (l *Loaders) Chip(id int) (*model.Chip, error) {
+	value, err := l.chipByID.Load(id)
+	chip, _ := value.(*model.Chip)
+	return chip, err
+}
+
+// Brand returns the brand with id, or nil if it does not exist
+func (l *Loaders) Brand(id string) (*model.Brand, error) {
+	value, err := l.brandByID.Load(id)
+	brand, _ := value.(*model.Brand)
+	return brand, err
+}
+
+// User returns the user with id, or nil if it does not exist
+func (l *Loaders) User(id int) (*model.User, error) {
+	value, err := l.userByID.Load(id)
+	user, _ := value.(*model.User)
+	return user, err
+}
+
+// Followers returns the number of followers of the user with id
+func (l *Loaders) Followers(id int) (int, error) {
+	value, err := l.followersByUser.Load(id)
+	followers, _ := value.(int)
+	return followers, err
+}
+
+// Barcodes returns the barcodes of the chip with id
+func (l *Loaders) Barcodes(id int) ([]string, error) {
+	value, err := l.barcodesByChip.Load(id)
+	barcodes, _ := value.([]string)
+	return barcodes, err
+}
+
+// reviewKey is a review as seen by a user, who may have liked it. Viewer is 0
+// when no one is logged in.
+type reviewKey struct {
+	id     int
+	viewer int
+}
+
+// Review returns the review with id as seen by viewer, or nil if it does not
+// exist or is hidden
+func (l *Loaders) Review(id int, viewer *int) (*model.Review, error) {
+	key := reviewKey{id: id}
+	if viewer != nil {
+		key.viewer = *viewer
+	}
+	value, err := l.reviewByID.Load(key)
+	review, _ := value.(*model.Review)
+	return review, err
+}
+
+// Revisions returns the earlier versions of the review with id, latest first
+func (l *Loaders) Revisions(id int) ([]*model.ReviewRevision, error) {
+	value, err := l.revisionsByReview.Load(id)
+	revisions, _ := value.([]*model.ReviewRevision)
+	return revisions, err
+}
+
+func intKeys(keys []interface{}) []int {
+	ids := make([]int, len(keys))
+	for i, key := range keys {
+		ids[i] = key.(int)
+	}
+	return ids
+}
+
+func stringKeys(keys []interface{}) []string {
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = key.(string)
+	}
+	return ids
+}
+
+func fetchChips(db *pgxpool.Pool) FetchFunc {
+	return func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		rows, err := db.Query(ctx, `SELECT `+model.ChipColumns+`
+		FROM chips WHERE id = ANY($1)`, intKeys(keys))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		chips := map[interface{}]interface{}{}
+		for rows.Next() {
+			chip, err := model.ScanChip(rows)
+			if err != nil {
+				return nil, err
+			}
+			chips[chip.ID] = chip
+		}
+		return chips, rows.Err()
+	}
+}
+
+func fetchBrands(db *pgxpool.Pool) FetchFunc {
+	return func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		rows, err := db.Query(ctx, `SELECT id, image, name, count, categories FROM brands WHERE id = ANY($1)`, stringKeys(keys))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		brands := map[interface{}]interface{}{}
+		for rows.Next() {
+			brand := &model.Brand{}
+			err := rows.Scan(&brand.ID, &brand.Image, &brand.Name, &brand.Count, &brand.Categories)
+			if err != nil {
+				return nil, err
+			}
+			brands[brand.ID] = brand
+		}
+		return brands, rows.Err()
+	}
+}
+
+func fetchUsers(db *pgxpool.Pool) FetchFunc {
+	return func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		rows, err := db.Query(ctx, `SELECT id, username, firstname, lastname, image, created, following FROM users WHERE id = ANY($1)`, intKeys(keys))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		users := map[interface{}]interface{}{}
+		for rows.Next() {
+			user := &model.User{}
+			err := rows.Scan(&user.ID, &user.Username, &user.Firstname, &user.Lastname, &user.Image, &user.Created, &user.Following)
+			if err != nil {
+				return nil, err
+			}
+			users[user.ID] = user
+		}
+		return users, rows.Err()
+	}
+}
+
+func fetchFollowers(db *pgxpool.Pool) FetchFunc {
+	return func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		rows, err := db.Query(ctx, `SELECT id, followers FROM users WHERE id = ANY($1)`, intKeys(keys))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		followers := map[interface{}]interface{}{}
+		for rows.Next() {
+			var id, count int
+			err := rows.Scan(&id, &count)
+			if err != nil {
+				return nil, err
+			}
+			followers[id] = count
+		}
+		return followers, rows.Err()
+	}
+}
+
+func fetchBarcodes(db *pgxpool.Pool) FetchFunc {
+	return func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		rows, err := db.Query(ctx, `SELECT chips_id, code FROM barcodes WHERE chips_id = ANY($1) ORDER BY created, gtin`, intKeys(keys))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		barcodes := map[interface{}]interface{}{}
+		for rows.Next() {
+			var id int
+			var code string
+			err := rows.Scan(&id, &code)
+			if err != nil {
+				return nil, err
+			}
+			chipBarcodes, _ := barcodes[id].([]string)
+			barcodes[id] = append(chipBarcodes, code)
+		}
+		return barcodes, rows.Err()
+	}
+}
+
+func fetchReviews(db *pgxpool.Pool) FetchFunc {
+	return func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		// Requests usually have one viewer, so this is one query
+		idsByViewer := map[int][]int{}
+		for _, key := range keys {
+			k := key.(reviewKey)
+			idsByViewer[k.viewer] = append(idsByViewer[k.viewer], k.id)
+		}
+		reviews := map[interface{}]interface{}{}
+		for viewer, ids := range idsByViewer {
+			err := fetchViewedReviews(ctx, db, viewer, ids, reviews)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return reviews, nil
+	}
+}
+
+func fetchViewedReviews(ctx context.Context, db *pgxpool.Pool, viewer int, ids []int, reviews map[interface{}]interface{}) error {
+	rows, err := db.Query(ctx, `SELECT `+model.ReviewColumns+`, likes.user_id IS NOT NULL
+	FROM reviews LEFT JOIN likes ON reviews.id=likes.review_id AND likes.user_id=$2
+	WHERE reviews.id = ANY($1) AND reviews.hidden IS NULL`, ids, viewer)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		review := &model.Review{}
+		err := rows.Scan(append(review.Fields(), &review.Liked)...)
+		if err != nil {
+			return err
+		}
+		reviews[reviewKey{id: review.ID, viewer: viewer}] = review
+	}
+	return rows.Err()
+}
+
+func fetchRevisions(db *pgxpool.Pool) FetchFunc {
+	return func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		rows, err := db.Query(ctx, `SELECT review_id, id, rating, review, created, replaced,
+		crunch, saltiness, flavor_intensity, greasiness, value
+		FROM review_revisions WHERE review_id = ANY($1)
+		ORDER BY replaced DESC`, intKeys(keys))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		revisions := map[interface{}]interface{}{}
+		for rows.Next() {
+			var reviewID int
+			revision := &model.ReviewRevision{Flavor: &model.Flavor{}}
+			err := rows.Scan(&reviewID, &revision.ID, &revision.Rating, &revision.Review, &revision.Created, &revision.Replaced,
+				&revision.Flavor.Crunch, &revision.Flavor.Saltiness, &revision.Flavor.FlavorIntensity, &revision.Flavor.Greasiness, &revision.Flavor.Value)
+			if err != nil {
+				return nil, err
+			}
+			reviewRevisions, _ := revisions[reviewID].([]*model.ReviewRevision)
+			revisions[reviewID] = append(reviewRevisions, revision)
+		}
+		return revisions, rows.Err()
+	}
+}
@@ -0,0 +1,12 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgconn"
+)
+
+// Execer is a pool, connection or transaction
+type Execer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
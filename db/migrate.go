@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key for the advisory lock that keeps two servers from migrating at once
+const migrationLock = 7305238
+
+// A Migration is a pair of NNNN_name.up.sql and NNNN_name.down.sql files
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrations returns all embedded migrations ordered by version
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", fileName)
+		}
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.%s.sql", fileName, direction)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has no version: %w", fileName, err)
+		}
+		content, err := migrationFiles.ReadFile("migrations/" + fileName)
+		if err != nil {
+			return nil, err
+		}
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = migration
+		}
+		if migration.Name != parts[1] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, parts[1])
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// withLock runs f on a single connection while holding the migration lock
+func withLock(ctx context.Context, pool *pgxpool.Pool, f func(conn *pgx.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLock)
+	if err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		name text NOT NULL,
+		applied timestamptz NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return err
+	}
+	return f(conn.Conn())
+}
+
+func appliedVersions(ctx context.Context, conn *pgx.Conn) (map[int]bool, error) {
+	rows, err := conn.Query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		err := rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// Status returns every migration and whether it has been applied
+func Status(ctx context.Context, pool *pgxpool.Pool) ([]Migration, map[int]bool, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, nil, err
+	}
+	var applied map[int]bool
+	err = withLock(ctx, pool, func(conn *pgx.Conn) error {
+		applied, err = appliedVersions(ctx, conn)
+		return err
+	})
+	return migrations, applied, err
+}
+
+// Up applies all pending migrations, each in its own transaction, and returns the applied ones
+func Up(ctx context.Context, pool *pgxpool.Pool) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	err = withLock(ctx, pool, func(conn *pgx.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if applied[migration.Version] {
+				continue
+			}
+			err = run(ctx, conn, migration.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the latest applied migrations and returns the reverted ones
+func Down(ctx context.Context, pool *pgxpool.Pool, steps int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	err = withLock(ctx, pool, func(conn *pgx.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := migrations[i]
+			if !applied[migration.Version] {
+				continue
+			}
+			err = run(ctx, conn, migration.Down, `DELETE FROM schema_migrations WHERE version=$1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Baseline records the migrations up to version as applied without running
+// them, for a database whose schema was created before migrations were
+// tracked. It returns the migrations that were recorded.
+func Baseline(ctx context.Context, pool *pgxpool.Pool, version int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	known := false
+	for _, migration := range migrations {
+		known = known || migration.Version == version
+	}
+	if !known {
+		return nil, fmt.Errorf("no migration has version %d", version)
+	}
+	var done []Migration
+	err = withLock(ctx, pool, func(conn *pgx.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if migration.Version > version || applied[migration.Version] {
+				continue
+			}
+			_, err = conn.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// run executes a migration script and records it in the same transaction
+func run(ctx context.Context, conn *pgx.Conn, script string, record string, args ...interface{}) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, script)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,8 @@
+DROP TABLE follows;
+DROP TABLE likes;
+DROP TABLE reviews;
+DROP TABLE chips;
+DROP TABLE brands;
+DROP TABLE users;
+DROP FUNCTION follows_count;
+DROP FUNCTION likes_count;
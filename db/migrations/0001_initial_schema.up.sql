@@ -0,0 +1,105 @@
+-- Used by word_similarity in search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE users (
+    id serial PRIMARY KEY,
+    username text NOT NULL UNIQUE,
+    email text NOT NULL UNIQUE,
+    password text NOT NULL,
+    firstname text,
+    lastname text,
+    role text NOT NULL DEFAULT 'user',
+    image text,
+    created timestamptz NOT NULL DEFAULT NOW(),
+    logout timestamptz NOT NULL DEFAULT NOW(),
+    following integer NOT NULL DEFAULT 0,
+    followers integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE brands (
+    id text PRIMARY KEY,
+    name text NOT NULL,
+    image text,
+    count integer NOT NULL DEFAULT 0,
+    categories json
+);
+
+CREATE TABLE chips (
+    id serial PRIMARY KEY,
+    brand_id text NOT NULL REFERENCES brands (id),
+    name text NOT NULL,
+    slug text NOT NULL,
+    category text NOT NULL,
+    subcategory text,
+    image text,
+    ingredients text,
+    rating double precision NOT NULL DEFAULT 0,
+    reviews integer NOT NULL DEFAULT 0,
+    UNIQUE (brand_id, slug)
+);
+
+CREATE INDEX chips_category_idx ON chips (category, subcategory);
+
+CREATE TABLE reviews (
+    id serial PRIMARY KEY,
+    chips_id integer NOT NULL REFERENCES chips (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    rating integer NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    review text,
+    created timestamptz NOT NULL DEFAULT NOW(),
+    edited timestamptz,
+    likes integer NOT NULL DEFAULT 0,
+    UNIQUE (chips_id, user_id)
+);
+
+CREATE INDEX reviews_user_id_idx ON reviews (user_id, created DESC);
+CREATE INDEX reviews_chips_id_idx ON reviews (chips_id, created DESC);
+
+CREATE TABLE likes (
+    review_id integer NOT NULL REFERENCES reviews (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (review_id, user_id)
+);
+
+CREATE TABLE follows (
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    follows_user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, follows_user_id),
+    CHECK (user_id <> follows_user_id)
+);
+
+CREATE INDEX follows_follows_user_id_idx ON follows (follows_user_id);
+
+-- reviews.likes is kept up to date by the database
+CREATE FUNCTION likes_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE reviews SET likes = likes + 1 WHERE id = NEW.review_id;
+    ELSE
+        UPDATE reviews SET likes = likes - 1 WHERE id = OLD.review_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER likes_count AFTER INSERT OR DELETE ON likes
+    FOR EACH ROW EXECUTE FUNCTION likes_count();
+
+-- users.following and users.followers are kept up to date by the database
+CREATE FUNCTION follows_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE users SET following = following + 1 WHERE id = NEW.user_id;
+        UPDATE users SET followers = followers + 1 WHERE id = NEW.follows_user_id;
+    ELSE
+        UPDATE users SET following = following - 1 WHERE id = OLD.user_id;
+        UPDATE users SET followers = followers - 1 WHERE id = OLD.follows_user_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER follows_count AFTER INSERT OR DELETE ON follows
+    FOR EACH ROW EXECUTE FUNCTION follows_count();
@@ -0,0 +1,13 @@
+CREATE TABLE sessions (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    -- Identifies the only refresh token that is valid, rotated on every refresh
+    token text NOT NULL,
+    device text,
+    user_agent text,
+    ip text,
+    created timestamptz NOT NULL DEFAULT NOW(),
+    last_used timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
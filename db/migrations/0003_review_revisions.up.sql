@@ -0,0 +1,12 @@
+CREATE TABLE review_revisions (
+    id serial PRIMARY KEY,
+    review_id integer NOT NULL REFERENCES reviews (id) ON DELETE CASCADE,
+    rating integer NOT NULL,
+    review text,
+    -- When this version was written
+    created timestamptz NOT NULL,
+    -- When this version was replaced by an edit
+    replaced timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX review_revisions_review_id_idx ON review_revisions (review_id);
@@ -0,0 +1,6 @@
+DROP INDEX chips_rating_idx;
+DROP INDEX chips_name_idx;
+DROP INDEX reviews_chips_id_idx;
+DROP INDEX reviews_user_id_idx;
+CREATE INDEX reviews_user_id_idx ON reviews (user_id, created DESC);
+CREATE INDEX reviews_chips_id_idx ON reviews (chips_id, created DESC);
@@ -0,0 +1,7 @@
+-- Indexes matching the sort keys used for cursor pagination
+DROP INDEX reviews_user_id_idx;
+DROP INDEX reviews_chips_id_idx;
+CREATE INDEX reviews_user_id_idx ON reviews (user_id, created DESC, id DESC);
+CREATE INDEX reviews_chips_id_idx ON reviews (chips_id, created DESC, id DESC);
+CREATE INDEX chips_name_idx ON chips (name, id);
+CREATE INDEX chips_rating_idx ON chips (rating DESC, name, id);
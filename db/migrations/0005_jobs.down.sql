@@ -0,0 +1,2 @@
+ALTER TABLE chips DROP COLUMN image_status;
+DROP TABLE jobs;
@@ -0,0 +1,18 @@
+CREATE TABLE jobs (
+    id bigserial PRIMARY KEY,
+    kind text NOT NULL,
+    payload jsonb NOT NULL DEFAULT '{}',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 5,
+    run_at timestamptz NOT NULL DEFAULT NOW(),
+    locked_until timestamptz,
+    last_error text,
+    failed timestamptz,
+    created timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Failed jobs are kept for inspection but never claimed
+CREATE INDEX jobs_run_at_idx ON jobs (run_at) WHERE failed IS NULL;
+
+ALTER TABLE chips ADD COLUMN image_status text CHECK (image_status IN ('pending', 'ready', 'failed'));
+UPDATE chips SET image_status = 'ready' WHERE image IS NOT NULL;
@@ -0,0 +1,8 @@
+-- Progress of "server images regenerate", so that interrupted runs can be
+-- resumed and several processes can share the work
+CREATE TABLE image_regenerations (
+    chips_id integer NOT NULL REFERENCES chips (id) ON DELETE CASCADE,
+    size text NOT NULL,
+    done timestamptz,
+    PRIMARY KEY (chips_id, size)
+);
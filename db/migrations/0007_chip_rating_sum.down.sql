@@ -0,0 +1 @@
+ALTER TABLE chips DROP COLUMN rating_sum;
@@ -0,0 +1,11 @@
+-- Sum of review ratings, so chips.rating can be updated incrementally
+ALTER TABLE chips ADD COLUMN rating_sum integer NOT NULL DEFAULT 0;
+
+UPDATE chips SET reviews = s.reviews, rating_sum = s.rating_sum,
+rating = CASE WHEN s.reviews > 0 THEN s.rating_sum::double precision / s.reviews ELSE 0 END
+FROM (
+    SELECT chips.id, COUNT(reviews.id) AS reviews, COALESCE(SUM(reviews.rating), 0) AS rating_sum
+    FROM chips LEFT JOIN reviews ON reviews.chips_id = chips.id
+    GROUP BY chips.id
+) AS s
+WHERE s.id = chips.id;
@@ -0,0 +1,2 @@
+DROP INDEX chips_score_idx;
+ALTER TABLE chips DROP COLUMN score;
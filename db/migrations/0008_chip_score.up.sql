@@ -0,0 +1,7 @@
+-- Bayesian average of the ratings, used to rank chips. Computed with the
+-- default prior, run "server recompute-stats" if another prior is configured.
+ALTER TABLE chips ADD COLUMN score double precision;
+
+UPDATE chips SET score = (5 * 6.0 + rating_sum) / (5 + reviews) WHERE reviews > 0;
+
+CREATE INDEX chips_score_idx ON chips (score DESC NULLS LAST, name, id);
@@ -0,0 +1,18 @@
+ALTER TABLE chips
+    DROP COLUMN crunch_sum,
+    DROP COLUMN crunch_count,
+    DROP COLUMN saltiness_sum,
+    DROP COLUMN saltiness_count,
+    DROP COLUMN flavor_intensity_sum,
+    DROP COLUMN flavor_intensity_count,
+    DROP COLUMN greasiness_sum,
+    DROP COLUMN greasiness_count,
+    DROP COLUMN value_sum,
+    DROP COLUMN value_count;
+
+ALTER TABLE reviews
+    DROP COLUMN crunch,
+    DROP COLUMN saltiness,
+    DROP COLUMN flavor_intensity,
+    DROP COLUMN greasiness,
+    DROP COLUMN value;
@@ -0,0 +1,20 @@
+-- Optional flavor sub-scores of reviews
+ALTER TABLE reviews
+    ADD COLUMN crunch smallint CHECK (crunch BETWEEN 1 AND 5),
+    ADD COLUMN saltiness smallint CHECK (saltiness BETWEEN 1 AND 5),
+    ADD COLUMN flavor_intensity smallint CHECK (flavor_intensity BETWEEN 1 AND 5),
+    ADD COLUMN greasiness smallint CHECK (greasiness BETWEEN 1 AND 5),
+    ADD COLUMN value smallint CHECK (value BETWEEN 1 AND 5);
+
+-- Sum and number of the sub-scores given in the reviews of a chip
+ALTER TABLE chips
+    ADD COLUMN crunch_sum integer NOT NULL DEFAULT 0,
+    ADD COLUMN crunch_count integer NOT NULL DEFAULT 0,
+    ADD COLUMN saltiness_sum integer NOT NULL DEFAULT 0,
+    ADD COLUMN saltiness_count integer NOT NULL DEFAULT 0,
+    ADD COLUMN flavor_intensity_sum integer NOT NULL DEFAULT 0,
+    ADD COLUMN flavor_intensity_count integer NOT NULL DEFAULT 0,
+    ADD COLUMN greasiness_sum integer NOT NULL DEFAULT 0,
+    ADD COLUMN greasiness_count integer NOT NULL DEFAULT 0,
+    ADD COLUMN value_sum integer NOT NULL DEFAULT 0,
+    ADD COLUMN value_count integer NOT NULL DEFAULT 0;
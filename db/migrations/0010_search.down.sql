@@ -0,0 +1,8 @@
+DROP INDEX users_username_trgm_idx;
+DROP INDEX brands_name_trgm_idx;
+DROP INDEX users_search_idx;
+DROP INDEX brands_search_idx;
+
+ALTER TABLE users DROP COLUMN search;
+ALTER TABLE brands DROP COLUMN search;
+ALTER TABLE chips DROP COLUMN search;
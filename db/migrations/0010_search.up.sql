@@ -0,0 +1,22 @@
+-- Documents for full-text search. Chips are matched together with the name of
+-- their brand, user names are not stemmed.
+ALTER TABLE chips ADD COLUMN search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('swedish', name), 'A') ||
+    setweight(to_tsvector('swedish', category || ' ' || COALESCE(subcategory, '')), 'C') ||
+    setweight(to_tsvector('swedish', COALESCE(ingredients, '')), 'D')
+) STORED;
+
+ALTER TABLE brands ADD COLUMN search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('swedish', name), 'B')
+) STORED;
+
+ALTER TABLE users ADD COLUMN search tsvector GENERATED ALWAYS AS (
+    to_tsvector('simple', username || ' ' || COALESCE(firstname, '') || ' ' || COALESCE(lastname, ''))
+) STORED;
+
+CREATE INDEX brands_search_idx ON brands USING gin (search);
+CREATE INDEX users_search_idx ON users USING gin (search);
+
+-- Trigram fallback for misspelled brand and user names
+CREATE INDEX brands_name_trgm_idx ON brands USING gin (name gin_trgm_ops);
+CREATE INDEX users_username_trgm_idx ON users USING gin (username gin_trgm_ops);
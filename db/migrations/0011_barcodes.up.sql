@@ -0,0 +1,10 @@
+-- EAN/GTIN barcodes of chips, a chip has one for every pack size. gtin is the
+-- code padded to 14 digits and code is the code as entered.
+CREATE TABLE barcodes (
+    gtin text PRIMARY KEY CHECK (gtin ~ '^[0-9]{14}$'),
+    code text NOT NULL,
+    chips_id integer NOT NULL REFERENCES chips (id) ON DELETE CASCADE,
+    created timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX barcodes_chips_id_idx ON barcodes (chips_id);
@@ -0,0 +1,3 @@
+DROP TABLE comments;
+DROP FUNCTION comments_count;
+ALTER TABLE reviews DROP COLUMN comments;
@@ -0,0 +1,47 @@
+-- Comments on reviews, replies have a parent comment on the same review.
+-- Deleted comments keep their row without a body so that replies stay in place.
+CREATE TABLE comments (
+    id serial PRIMARY KEY,
+    review_id integer NOT NULL REFERENCES reviews (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    parent_id integer REFERENCES comments (id) ON DELETE CASCADE,
+    body text,
+    created timestamptz NOT NULL DEFAULT NOW(),
+    edited timestamptz,
+    deleted timestamptz,
+    replies integer NOT NULL DEFAULT 0,
+    CHECK ((body IS NULL) = (deleted IS NOT NULL))
+);
+
+CREATE INDEX comments_review_id_idx ON comments (review_id, created, id) WHERE parent_id IS NULL;
+CREATE INDEX comments_parent_id_idx ON comments (parent_id, created, id);
+
+ALTER TABLE reviews ADD COLUMN comments integer NOT NULL DEFAULT 0;
+
+-- reviews.comments counts the comments that are not deleted and
+-- comments.replies counts every reply, both are kept up to date by the database
+CREATE FUNCTION comments_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE reviews SET comments = comments + 1 WHERE id = NEW.review_id;
+        IF NEW.parent_id IS NOT NULL THEN
+            UPDATE comments SET replies = replies + 1 WHERE id = NEW.parent_id;
+        END IF;
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.deleted IS NULL AND NEW.deleted IS NOT NULL THEN
+            UPDATE reviews SET comments = comments - 1 WHERE id = NEW.review_id;
+        END IF;
+    ELSE
+        IF OLD.deleted IS NULL THEN
+            UPDATE reviews SET comments = comments - 1 WHERE id = OLD.review_id;
+        END IF;
+        IF OLD.parent_id IS NOT NULL THEN
+            UPDATE comments SET replies = replies - 1 WHERE id = OLD.parent_id;
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER comments_count AFTER INSERT OR DELETE OR UPDATE OF deleted ON comments
+    FOR EACH ROW EXECUTE FUNCTION comments_count();
@@ -0,0 +1,15 @@
+-- Notifications of user_id, actor_ids are the users that caused it with the
+-- latest first. Unread notifications of the same kind and review are grouped.
+CREATE TABLE notifications (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    kind text NOT NULL CHECK (kind IN ('like', 'follow', 'review')),
+    review_id integer REFERENCES reviews (id) ON DELETE CASCADE,
+    actor_ids integer[] NOT NULL,
+    created timestamptz NOT NULL DEFAULT NOW(),
+    updated timestamptz NOT NULL DEFAULT NOW(),
+    read timestamptz
+);
+
+CREATE UNIQUE INDEX notifications_unread_idx ON notifications (user_id, kind, COALESCE(review_id, 0)) WHERE read IS NULL;
+CREATE INDEX notifications_user_id_idx ON notifications (user_id, updated DESC, id DESC);
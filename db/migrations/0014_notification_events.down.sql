@@ -0,0 +1,2 @@
+DROP TRIGGER notifications_publish ON notifications;
+DROP FUNCTION notifications_publish;
@@ -0,0 +1,11 @@
+-- Sends a pubsub event when a notification is added or grouped with a new
+-- actor, the payload is a pubsub.Event on the events channel
+CREATE FUNCTION notifications_publish() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('events', json_build_object('topic', 'notifications:' || NEW.user_id, 'id', NEW.id)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notifications_publish AFTER INSERT OR UPDATE OF actor_ids ON notifications
+    FOR EACH ROW WHEN (NEW.read IS NULL) EXECUTE FUNCTION notifications_publish();
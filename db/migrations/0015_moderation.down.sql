@@ -0,0 +1,32 @@
+DROP TRIGGER comments_count ON comments;
+CREATE TRIGGER comments_count AFTER INSERT OR DELETE OR UPDATE OF deleted ON comments
+    FOR EACH ROW EXECUTE FUNCTION comments_count();
+
+CREATE OR REPLACE FUNCTION comments_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE reviews SET comments = comments + 1 WHERE id = NEW.review_id;
+        IF NEW.parent_id IS NOT NULL THEN
+            UPDATE comments SET replies = replies + 1 WHERE id = NEW.parent_id;
+        END IF;
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.deleted IS NULL AND NEW.deleted IS NOT NULL THEN
+            UPDATE reviews SET comments = comments - 1 WHERE id = NEW.review_id;
+        END IF;
+    ELSE
+        IF OLD.deleted IS NULL THEN
+            UPDATE reviews SET comments = comments - 1 WHERE id = OLD.review_id;
+        END IF;
+        IF OLD.parent_id IS NOT NULL THEN
+            UPDATE comments SET replies = replies - 1 WHERE id = OLD.parent_id;
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE users DROP COLUMN banned_until;
+ALTER TABLE comments DROP COLUMN hidden;
+ALTER TABLE reviews DROP COLUMN hidden;
+DROP TABLE moderation_log;
+DROP TABLE reports;
@@ -0,0 +1,72 @@
+-- Reports of reviews, comments and users. user_id is the reported user or the
+-- author of the reported review or comment, content is the reported text when
+-- it was reported.
+CREATE TABLE reports (
+    id serial PRIMARY KEY,
+    reporter_id integer REFERENCES users (id) ON DELETE SET NULL,
+    content_type text NOT NULL CHECK (content_type IN ('review', 'comment', 'user')),
+    content_id integer NOT NULL,
+    content text,
+    user_id integer REFERENCES users (id) ON DELETE SET NULL,
+    reason text NOT NULL,
+    created timestamptz NOT NULL DEFAULT NOW(),
+    resolved timestamptz,
+    action text CHECK (action IN ('dismiss', 'hide', 'delete', 'warn', 'ban')),
+    CHECK ((resolved IS NULL) = (action IS NULL))
+);
+
+-- A user can only have one unresolved report of the same content
+CREATE UNIQUE INDEX reports_unresolved_idx ON reports (reporter_id, content_type, content_id) WHERE resolved IS NULL;
+CREATE INDEX reports_queue_idx ON reports (created, id) WHERE resolved IS NULL;
+CREATE INDEX reports_content_idx ON reports (content_type, content_id);
+
+-- Every moderation action, kept after the content and users are deleted
+CREATE TABLE moderation_log (
+    id serial PRIMARY KEY,
+    actor_id integer REFERENCES users (id) ON DELETE SET NULL,
+    action text NOT NULL,
+    content_type text NOT NULL,
+    content_id integer NOT NULL,
+    user_id integer REFERENCES users (id) ON DELETE SET NULL,
+    report_id integer REFERENCES reports (id) ON DELETE SET NULL,
+    note text,
+    created timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX moderation_log_created_idx ON moderation_log (created DESC, id DESC);
+
+-- Hidden reviews are only shown to moderators and are not counted in the
+-- chip statistics, hidden comments are shown as deleted
+ALTER TABLE reviews ADD COLUMN hidden timestamptz;
+ALTER TABLE comments ADD COLUMN hidden timestamptz;
+
+-- Banned users can not log in, 'infinity' bans permanently
+ALTER TABLE users ADD COLUMN banned_until timestamptz;
+
+-- reviews.comments counts the comments that are neither deleted nor hidden
+CREATE OR REPLACE FUNCTION comments_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE reviews SET comments = comments + 1 WHERE id = NEW.review_id;
+        IF NEW.parent_id IS NOT NULL THEN
+            UPDATE comments SET replies = replies + 1 WHERE id = NEW.parent_id;
+        END IF;
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.deleted IS NULL AND OLD.hidden IS NULL AND (NEW.deleted IS NOT NULL OR NEW.hidden IS NOT NULL) THEN
+            UPDATE reviews SET comments = comments - 1 WHERE id = NEW.review_id;
+        END IF;
+    ELSE
+        IF OLD.deleted IS NULL AND OLD.hidden IS NULL THEN
+            UPDATE reviews SET comments = comments - 1 WHERE id = OLD.review_id;
+        END IF;
+        IF OLD.parent_id IS NOT NULL THEN
+            UPDATE comments SET replies = replies - 1 WHERE id = OLD.parent_id;
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER comments_count ON comments;
+CREATE TRIGGER comments_count AFTER INSERT OR DELETE OR UPDATE OF deleted, hidden ON comments
+    FOR EACH ROW EXECUTE FUNCTION comments_count();
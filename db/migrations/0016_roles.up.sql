@@ -0,0 +1,2 @@
+-- Roles grant the permissions listed in auth.rolePermissions
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'moderator', 'admin'));
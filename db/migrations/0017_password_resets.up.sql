@@ -0,0 +1,12 @@
+-- Pending password resets, the id is the token sent to the client and the
+-- code emailed to the user is only stored hashed. user_id is NULL when the
+-- email has no account, so such a reset can never be used.
+CREATE TABLE password_resets (
+    id text PRIMARY KEY,
+    user_id integer REFERENCES users (id) ON DELETE CASCADE,
+    code_hash text NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    created timestamptz NOT NULL DEFAULT NOW(),
+    expires timestamptz NOT NULL,
+    used timestamptz
+);
@@ -0,0 +1,2 @@
+DROP INDEX chips_name_trgm_idx;
+DROP INDEX chips_search_idx;
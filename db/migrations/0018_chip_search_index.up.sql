@@ -0,0 +1,4 @@
+-- Chips are matched together with their brand, which no index covers, so
+-- searches first collect candidates by chip or brand with these indexes
+CREATE INDEX chips_search_idx ON chips USING gin (search);
+CREATE INDEX chips_name_trgm_idx ON chips USING gin (name gin_trgm_ops);
@@ -0,0 +1,6 @@
+ALTER TABLE review_revisions
+    DROP COLUMN crunch,
+    DROP COLUMN saltiness,
+    DROP COLUMN flavor_intensity,
+    DROP COLUMN greasiness,
+    DROP COLUMN value;
@@ -0,0 +1,7 @@
+-- Flavor sub-scores of the replaced version of a review
+ALTER TABLE review_revisions
+    ADD COLUMN crunch smallint,
+    ADD COLUMN saltiness smallint,
+    ADD COLUMN flavor_intensity smallint,
+    ADD COLUMN greasiness smallint,
+    ADD COLUMN value smallint;
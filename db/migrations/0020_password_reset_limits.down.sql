@@ -0,0 +1,6 @@
+DROP INDEX password_resets_ip_idx;
+DROP INDEX password_resets_email_idx;
+
+ALTER TABLE password_resets
+    DROP COLUMN ip,
+    DROP COLUMN email;
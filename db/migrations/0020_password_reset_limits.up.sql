@@ -0,0 +1,10 @@
+-- Resets are limited per email and per client IP, so both are kept until the
+-- rows are purged. Resets created before this only lived for ten minutes.
+DELETE FROM password_resets;
+
+ALTER TABLE password_resets
+    ADD COLUMN email text NOT NULL,
+    ADD COLUMN ip text NOT NULL;
+
+CREATE INDEX password_resets_email_idx ON password_resets (email, created);
+CREATE INDEX password_resets_ip_idx ON password_resets (ip, created);
@@ -0,0 +1,12 @@
+DELETE FROM verification_codes WHERE purpose <> 'password_reset';
+
+DROP INDEX verification_codes_ip_idx;
+DROP INDEX verification_codes_email_idx;
+CREATE INDEX password_resets_email_idx ON verification_codes (email, created);
+CREATE INDEX password_resets_ip_idx ON verification_codes (ip, created);
+
+ALTER TABLE verification_codes DROP COLUMN purpose;
+
+ALTER TABLE verification_codes RENAME CONSTRAINT verification_codes_user_id_fkey TO password_resets_user_id_fkey;
+ALTER INDEX verification_codes_pkey RENAME TO password_resets_pkey;
+ALTER TABLE verification_codes RENAME TO password_resets;
@@ -0,0 +1,13 @@
+-- Email verification codes are stored like password reset codes, purpose
+-- tells them apart
+ALTER TABLE password_resets RENAME TO verification_codes;
+ALTER INDEX password_resets_pkey RENAME TO verification_codes_pkey;
+ALTER TABLE verification_codes RENAME CONSTRAINT password_resets_user_id_fkey TO verification_codes_user_id_fkey;
+
+ALTER TABLE verification_codes ADD COLUMN purpose text NOT NULL DEFAULT 'password_reset';
+ALTER TABLE verification_codes ALTER COLUMN purpose DROP DEFAULT;
+
+DROP INDEX password_resets_email_idx;
+DROP INDEX password_resets_ip_idx;
+CREATE INDEX verification_codes_email_idx ON verification_codes (purpose, email, created);
+CREATE INDEX verification_codes_ip_idx ON verification_codes (purpose, ip, created);
@@ -0,0 +1,31 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/jackc/pgx/v4"
+)
+
+// setBarcodes replaces the barcodes of a chip. The codes must have been
+// validated, a barcode of another chip is a barcodes_pkey violation.
+func setBarcodes(ctx context.Context, tx pgx.Tx, chipID int, codes []string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM barcodes WHERE chips_id=$1`, chipID)
+	if err != nil {
+		return err
+	}
+	gtins := map[string]bool{}
+	for _, code := range codes {
+		gtin, _ := model.GTIN(code)
+		if gtins[gtin] {
+			// The same barcode written another way, e.g. as UPC-A and EAN-13
+			continue
+		}
+		gtins[gtin] = true
+		_, err = tx.Exec(ctx, `INSERT INTO barcodes (gtin, code, chips_id) VALUES ($1, $2, $3)`, gtin, code, chipID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/c-wiren/snackstoppen-backend/apperr"
+	"github.com/c-wiren/snackstoppen-backend/auth"
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+)
+
+// HasPermission implements @hasPermission, the field is only resolved for
+// users whose role has the permission
+func HasPermission(ctx context.Context, obj interface{}, next graphql.Resolver, perm model.Permission) (interface{}, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	if !user.Can(perm) {
+		return nil, apperr.New(apperr.Forbidden, "Missing permission "+string(perm))
+	}
+	return next(ctx)
+}
@@ -0,0 +1,41 @@
+package graph
+
+import (
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/c-wiren/snackstoppen-backend/stats"
+)
+
+// reviewScores returns the scores of a review that are counted in the stats
+// of its chip
+func reviewScores(review *model.Review) stats.Scores {
+	scores := stats.Scores{Flavor: flavorScores(review.Flavor)}
+	if review.Rating != nil {
+		scores.Rating = *review.Rating
+	}
+	return scores
+}
+
+// flavorScores orders flavor sub-scores like stats.FlavorDimensions
+func flavorScores(flavor model.Flavor) [len(stats.FlavorDimensions)]*int {
+	return [...]*int{flavor.Crunch, flavor.Saltiness, flavor.FlavorIntensity, flavor.Greasiness, flavor.Value}
+}
+
+// flavorProfile returns the average flavor sub-scores of a chip
+func flavorProfile(chip *model.Chip) *model.FlavorProfile {
+	var averages [len(stats.FlavorDimensions)]*model.FlavorAverage
+	for i, count := range chip.FlavorCounts {
+		if count > 0 {
+			averages[i] = &model.FlavorAverage{
+				Average: float64(chip.FlavorSums[i]) / float64(count),
+				Count:   count,
+			}
+		}
+	}
+	return &model.FlavorProfile{
+		Crunch:          averages[0],
+		Saltiness:       averages[1],
+		FlavorIntensity: averages[2],
+		Greasiness:      averages[3],
+		Value:           averages[4],
+	}
+}
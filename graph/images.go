@@ -0,0 +1,298 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/c-wiren/snackstoppen-backend/db"
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/c-wiren/snackstoppen-backend/jobs"
+	"github.com/c-wiren/snackstoppen-backend/storage"
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+	"github.com/gen2brain/heic"
+)
+
+// maxImageBytes limits the size of uploaded images
+const maxImageBytes = 20 << 20
+
+// maxImagePixels limits the width times height of uploaded images, since a
+// small file can decode to gigabytes of pixels
+const maxImagePixels = 50_000_000
+
+var errUnsupportedImage = errors.New("unsupported image format")
+
+// imageSizes are the bounding boxes uploaded images are resized to, the
+// original is stored as well
+var imageSizes = []struct {
+	Prefix string
+	Size   int
+}{
+	{"sm", 60},
+	{"md", 224},
+	{"lg", 640},
+}
+
+// sniffImage detects the format of an image from its content, the file name
+// and content type sent by the client are not trusted
+func sniffImage(data []byte) string {
+	// HEIC is an ISO media file with a HEIF brand, e.g. ????ftypheic
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		switch string(data[8:12]) {
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+			return "image/heic"
+		}
+	}
+	return http.DetectContentType(data)
+}
+
+// decodeImage decodes an uploaded PNG, JPEG, WebP or HEIC image. JPEG images
+// are rotated according to their EXIF orientation. Only the pixels are kept,
+// so EXIF and other metadata are stripped when the image is encoded again.
+func decodeImage(file io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(file, maxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageBytes {
+		return nil, fmt.Errorf("image larger than %d bytes", maxImageBytes)
+	}
+	format := sniffImage(data)
+	var decodeConfig func(io.Reader) (image.Config, error)
+	switch format {
+	case "image/png":
+		decodeConfig = png.DecodeConfig
+	case "image/jpeg":
+		decodeConfig = jpeg.DecodeConfig
+	case "image/webp":
+		decodeConfig = webp.DecodeConfig
+	case "image/heic":
+		decodeConfig = heic.DecodeConfig
+	default:
+		return nil, errUnsupportedImage
+	}
+	// Check the dimensions in the header before decoding any pixels
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("image larger than %d pixels", maxImagePixels)
+	}
+	switch format {
+	case "image/png":
+		return png.Decode(bytes.NewReader(data))
+	case "image/jpeg":
+		return imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	case "image/webp":
+		return webp.Decode(bytes.NewReader(data))
+	default:
+		// libheif applies the rotation stored in the container
+		return heic.Decode(bytes.NewReader(data))
+	}
+}
+
+// imageName returns the stored name of an image, PNG if it has transparency
+// and JPEG otherwise
+func imageName(base string, img image.Image) string {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return base + ".jpg"
+	}
+	return base + ".png"
+}
+
+// webpKey returns the key of the WebP version of an image
+func webpKey(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ".webp"
+}
+
+// putImage encodes an image in the format given by the key extension and
+// uploads it to the image store
+func (r *Resolver) putImage(ctx context.Context, key string, img image.Image) error {
+	buff := bytes.NewBuffer(nil)
+	var contentType string
+	var err error
+	switch path.Ext(key) {
+	case ".png":
+		contentType = "image/png"
+		err = png.Encode(buff, img)
+	case ".jpg":
+		contentType = "image/jpeg"
+		err = jpeg.Encode(buff, img, &jpeg.Options{Quality: 85})
+	case ".webp":
+		contentType = "image/webp"
+		err = webp.Encode(buff, img, &webp.Options{Quality: 80})
+	default:
+		err = errUnsupportedImage
+	}
+	if err != nil {
+		return err
+	}
+	return r.Images.Put(ctx, key, buff, int64(buff.Len()), contentType)
+}
+
+// imageJob creates the resized versions of an uploaded image, Chip is set for
+// chip images so that the image status of the chip is updated
+type imageJob struct {
+	Key  string `json:"key"`
+	Chip *int   `json:"chip,omitempty"`
+}
+
+const imageJobKind = "image"
+
+// uploadImage stores the original image, e.g. original/snacks/x.jpg, and
+// queues a job that creates the resized versions. If db is a transaction the
+// job only runs if it commits.
+func (r *Resolver) uploadImage(ctx context.Context, db db.Execer, key string, originalImage image.Image, chip *int) error {
+	err := r.putImage(ctx, "original/"+key, originalImage)
+	if err != nil {
+		return fmt.Errorf("upload original/%s: %w", key, err)
+	}
+	return jobs.Enqueue(ctx, db, imageJobKind, imageJob{Key: key, Chip: chip})
+}
+
+// selected reports whether prefix is in sizes, an empty list selects every size
+func selected(sizes []string, prefix string) bool {
+	for _, size := range sizes {
+		if size == prefix {
+			return true
+		}
+	}
+	return len(sizes) == 0
+}
+
+// IsImageSize reports whether prefix is one of the sizes images are resized to
+func IsImageSize(prefix string) bool {
+	for _, size := range imageSizes {
+		if size.Prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// resizeImage creates the resized versions of a stored original as PNG/JPEG
+// and WebP, e.g. sm/snacks/x.jpg, sm/snacks/x.webp, md/snacks/x.jpg and so on.
+// Only the given sizes are created, or every size if none are given.
+func (r *Resolver) resizeImage(ctx context.Context, key string, sizes ...string) error {
+	file, err := r.Images.Get(ctx, "original/"+key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	originalImage, _, err := image.Decode(file)
+	if err != nil {
+		return fmt.Errorf("decode original/%s: %w", key, err)
+	}
+	for _, size := range imageSizes {
+		if !selected(sizes, size.Prefix) {
+			continue
+		}
+		resizedImage := imaging.Fit(originalImage, size.Size, size.Size, imaging.Box)
+		for _, sizeKey := range []string{size.Prefix + "/" + key, size.Prefix + "/" + webpKey(key)} {
+			err = r.putImage(ctx, sizeKey, resizedImage)
+			if err != nil {
+				return fmt.Errorf("upload %s: %w", sizeKey, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RegenerateImage recreates the resized versions of a stored chip image from
+// its original, see resizeImage. The image status is updated with db, so a
+// caller holding a transaction does not need a second connection.
+func (r *Resolver) RegenerateImage(ctx context.Context, db db.Execer, chipID int, name string, sizes ...string) error {
+	err := r.resizeImage(ctx, "snacks/"+name, sizes...)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `UPDATE chips SET image_status='ready' WHERE id=$1 AND image=$2`, chipID, name)
+	return err
+}
+
+// processImage runs an imageJob
+func (r *Resolver) processImage(ctx context.Context, job *jobs.Job) error {
+	var payload imageJob
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return err
+	}
+	err = r.resizeImage(ctx, payload.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		// The original was removed before it was processed, retrying does not
+		// help
+		return r.setImageStatus(ctx, payload, "failed")
+	}
+	if err != nil && !job.LastAttempt() {
+		return err
+	}
+
+	status := "ready"
+	if err != nil {
+		status = "failed"
+	}
+	dbErr := r.setImageStatus(ctx, payload, status)
+	if err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// setImageStatus updates the image status of the chip of an imageJob, if it
+// still has this image
+func (r *Resolver) setImageStatus(ctx context.Context, payload imageJob, status string) error {
+	if payload.Chip == nil {
+		return nil
+	}
+	_, err := r.DB.Exec(ctx, `UPDATE chips SET image_status=$1
+	WHERE id=$2 AND 'snacks/' || image = $3`, status, *payload.Chip, payload.Key)
+	return err
+}
+
+// removeImage deletes the original image and every resized version
+func (r *Resolver) removeImage(ctx context.Context, key string) error {
+	keys := []string{"original/" + key}
+	for _, size := range imageSizes {
+		keys = append(keys, size.Prefix+"/"+key, size.Prefix+"/"+webpKey(key))
+	}
+	var firstErr error
+	for _, k := range keys {
+		err := r.Images.Delete(ctx, k)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("remove %s: %w", k, err)
+		}
+	}
+	return firstErr
+}
+
+// imageSet returns the URLs of every stored version of an image
+func (r *Resolver) imageSet(key string) *model.ImageSet {
+	set := &model.ImageSet{Original: r.Images.URL("original/" + key)}
+	for _, size := range imageSizes {
+		variant := &model.ImageVariant{
+			Size: size.Size,
+			URL:  r.Images.URL(size.Prefix + "/" + key),
+			Webp: r.Images.URL(size.Prefix + "/" + webpKey(key)),
+		}
+		switch size.Prefix {
+		case "sm":
+			set.Sm = variant
+		case "md":
+			set.Md = variant
+		case "lg":
+			set.Lg = variant
+		}
+	}
+	return set
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"errors"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// GTIN checks the check digit of an EAN-8, UPC-A, EAN-13 or GTIN-14 barcode
+// and returns it padded to 14 digits, so that a UPC-A and the EAN-13 of the
+// same product are equal. Spaces and dashes are ignored.
+func GTIN(code string) (string, bool) {
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return "", false
+	}
+	gtin := strings.Repeat("0", 14-len(code)) + code
+	// Digits are weighted 3 and 1 alternately from the right, the check digit
+	// makes the sum a multiple of 10
+	sum := 0
+	for i, r := range gtin {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+		digit := int(r - '0')
+		if i%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	if sum%10 != 0 {
+		return "", false
+	}
+	return gtin, true
+}
+
+// isGTIN validates barcodes with GTIN
+var isGTIN = validation.By(func(value interface{}) error {
+	code, _ := value.(string)
+	if _, ok := GTIN(code); !ok {
+		return errors.New("must be a valid EAN or GTIN barcode")
+	}
+	return nil
+})
@@ -0,0 +1,45 @@
+package model
+
+import "testing"
+
+func TestGTIN(t *testing.T) {
+	tests := []struct {
+		code string
+		gtin string
+		ok   bool
+	}{
+		// EAN-8
+		{"96385074", "00000096385074", true},
+		{"96385075", "", false},
+		// UPC-A equals the EAN-13 of the same product
+		{"036000291452", "00036000291452", true},
+		{"0036000291452", "00036000291452", true},
+		{"036000291453", "", false},
+		// EAN-13
+		{"4006381333931", "04006381333931", true},
+		{"7310500088853", "07310500088853", true},
+		{"4006381333932", "", false},
+		// GTIN-14
+		{"10012345678902", "10012345678902", true},
+		{"10012345678903", "", false},
+		// Spaces and dashes are ignored
+		{"400 6381 33393 1", "04006381333931", true},
+		{"4-006381-333931", "04006381333931", true},
+		// Lengths other than 8, 12, 13 and 14 digits
+		{"", "", false},
+		{"0000000", "", false},
+		{"000000000", "", false},
+		{"00000000000", "", false},
+		{"000000000000000", "", false},
+		// Only digits
+		{"4006381a33931", "", false},
+		{"40063813339३1", "", false},
+		{"+4006381333931", "", false},
+	}
+	for _, test := range tests {
+		gtin, ok := GTIN(test.code)
+		if gtin != test.gtin || ok != test.ok {
+			t.Errorf("GTIN(%q) = %q, %v, want %q, %v", test.code, gtin, ok, test.gtin, test.ok)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package model
+
+type Chip struct {
+	ID          int      `json:"id"`
+	BrandID     string   `json:"-"`
+	Category    string   `json:"category"`
+	Image       *string  `json:"image"`
+	ImageStatus *string  `json:"-"`
+	Ingredients *string  `json:"ingredients"`
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	Subcategory *string  `json:"subcategory"`
+	Rating      float64  `json:"rating"`
+	Reviews     int      `json:"reviews"`
+	Score       *float64 `json:"score"`
+	// FlavorSums and FlavorCounts are the flavor sub-scores of the reviews, in
+	// the order of stats.FlavorDimensions
+	FlavorSums   [5]int `json:"-"`
+	FlavorCounts [5]int `json:"-"`
+}
+
+// ChipColumns are the columns read by ScanChip
+const ChipColumns = `chips.name, chips.category, chips.subcategory, chips.slug, chips.image, chips.ingredients,
+	chips.id, chips.rating, chips.reviews, chips.brand_id, chips.image_status, chips.score,
+	chips.crunch_sum, chips.crunch_count, chips.saltiness_sum, chips.saltiness_count,
+	chips.flavor_intensity_sum, chips.flavor_intensity_count, chips.greasiness_sum, chips.greasiness_count,
+	chips.value_sum, chips.value_count`
+
+// ScanChip reads a chip from a row selected with ChipColumns
+func ScanChip(row interface {
+	Scan(dest ...interface{}) error
+}) (*Chip, error) {
+	chip := &Chip{}
+	dest := []interface{}{&chip.Name, &chip.Category, &chip.Subcategory, &chip.Slug, &chip.Image, &chip.Ingredients,
+		&chip.ID, &chip.Rating, &chip.Reviews, &chip.BrandID, &chip.ImageStatus, &chip.Score}
+	for i := range chip.FlavorSums {
+		dest = append(dest, &chip.FlavorSums[i], &chip.FlavorCounts[i])
+	}
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	return chip, nil
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+type Comment struct {
+	ID         int        `json:"id"`
+	ReviewID   int        `json:"-"`
+	UserID     int        `json:"-"`
+	ParentID   *int       `json:"parentId"`
+	Body       *string    `json:"body"`
+	Created    time.Time  `json:"created"`
+	Edited     *time.Time `json:"edited"`
+	Deleted    bool       `json:"deleted"`
+	ReplyCount int        `json:"replyCount"`
+}
+
+// CommentColumns are the columns read by Comment.Fields, hidden comments are
+// read as deleted
+const CommentColumns = `comments.id, comments.review_id, comments.user_id, comments.parent_id,
+	CASE WHEN comments.hidden IS NULL THEN comments.body END,
+	comments.created, comments.edited, (comments.deleted IS NOT NULL OR comments.hidden IS NOT NULL), comments.replies`
+
+// Fields returns the scan destinations of CommentColumns
+func (c *Comment) Fields() []interface{} {
+	return []interface{}{&c.ID, &c.ReviewID, &c.UserID, &c.ParentID, &c.Body, &c.Created, &c.Edited, &c.Deleted, &c.ReplyCount}
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+type Notification struct {
+	ID       int       `json:"id"`
+	Kind     string    `json:"-"`
+	ActorIDs []int     `json:"-"`
+	ReviewID *int      `json:"-"`
+	Updated  time.Time `json:"updated"`
+	Read     bool      `json:"read"`
+}
+
+// NotificationColumns are the columns read by Notification.Fields
+const NotificationColumns = `notifications.id, notifications.kind, notifications.actor_ids, notifications.review_id,
+	notifications.updated, notifications.read IS NOT NULL`
+
+// Fields returns the scan destinations of NotificationColumns
+func (n *Notification) Fields() []interface{} {
+	return []interface{}{&n.ID, &n.Kind, &n.ActorIDs, &n.ReviewID, &n.Updated, &n.Read}
+}
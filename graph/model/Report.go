@@ -0,0 +1,47 @@
+package model
+
+import "time"
+
+type Report struct {
+	ID        int    `json:"id"`
+	Type      string `json:"-"`
+	ContentID int    `json:"contentId"`
+	// Content is the reported text when it was reported
+	Content    *string    `json:"content"`
+	UserID     *int       `json:"-"`
+	ReporterID *int       `json:"-"`
+	Reason     string     `json:"reason"`
+	Created    time.Time  `json:"created"`
+	Resolved   *time.Time `json:"resolved"`
+	Action     *string    `json:"-"`
+}
+
+// ReportColumns are the columns read by Report.Fields
+const ReportColumns = `reports.id, reports.content_type, reports.content_id, reports.content, reports.user_id,
+	reports.reporter_id, reports.reason, reports.created, reports.resolved, reports.action`
+
+// Fields returns the scan destinations of ReportColumns
+func (r *Report) Fields() []interface{} {
+	return []interface{}{&r.ID, &r.Type, &r.ContentID, &r.Content, &r.UserID, &r.ReporterID, &r.Reason, &r.Created, &r.Resolved, &r.Action}
+}
+
+type ModerationLogEntry struct {
+	ID        int       `json:"id"`
+	ActorID   *int      `json:"-"`
+	Action    string    `json:"-"`
+	Type      string    `json:"-"`
+	ContentID int       `json:"contentId"`
+	UserID    *int      `json:"-"`
+	ReportID  *int      `json:"reportId"`
+	Note      *string   `json:"note"`
+	Created   time.Time `json:"created"`
+}
+
+// ModerationLogColumns are the columns read by ModerationLogEntry.Fields
+const ModerationLogColumns = `moderation_log.id, moderation_log.actor_id, moderation_log.action, moderation_log.content_type,
+	moderation_log.content_id, moderation_log.user_id, moderation_log.report_id, moderation_log.note, moderation_log.created`
+
+// Fields returns the scan destinations of ModerationLogColumns
+func (e *ModerationLogEntry) Fields() []interface{} {
+	return []interface{}{&e.ID, &e.ActorID, &e.Action, &e.Type, &e.ContentID, &e.UserID, &e.ReportID, &e.Note, &e.Created}
+}
@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+type Review struct {
+	ID      int        `json:"id"`
+	ChipsID int        `json:"-"`
+	UserID  int        `json:"-"`
+	Rating  *int       `json:"rating"`
+	Review  *string    `json:"review"`
+	Created *time.Time `json:"created"`
+	Edited  *time.Time `json:"edited"`
+	Likes   *int       `json:"likes"`
+	Liked   *bool      `json:"liked"`
+	Flavor  Flavor     `json:"flavor"`
+	// CommentCount is the number of comments that are not deleted
+	CommentCount *int `json:"commentCount"`
+	// Hidden reviews are not counted in the chip statistics
+	Hidden bool `json:"-"`
+}
+
+// Flavor is the flavor sub-scores of a review
+type Flavor struct {
+	Crunch          *int `json:"crunch"`
+	Saltiness       *int `json:"saltiness"`
+	FlavorIntensity *int `json:"flavorIntensity"`
+	Greasiness      *int `json:"greasiness"`
+	Value           *int `json:"value"`
+}
+
+// ReviewColumns are the columns read by Review.Fields
+const ReviewColumns = `reviews.id, reviews.rating, reviews.review, reviews.created, reviews.edited, reviews.likes,
+	reviews.user_id, reviews.chips_id,
+	reviews.crunch, reviews.saltiness, reviews.flavor_intensity, reviews.greasiness, reviews.value, reviews.comments,
+	reviews.hidden IS NOT NULL`
+
+// Fields returns the scan destinations of ReviewColumns
+func (r *Review) Fields() []interface{} {
+	return []interface{}{&r.ID, &r.Rating, &r.Review, &r.Created, &r.Edited, &r.Likes, &r.UserID, &r.ChipsID,
+		&r.Flavor.Crunch, &r.Flavor.Saltiness, &r.Flavor.FlavorIntensity, &r.Flavor.Greasiness, &r.Flavor.Value,
+		&r.CommentCount, &r.Hidden}
+}
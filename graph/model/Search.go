@@ -0,0 +1,17 @@
+package model
+
+type SearchHit struct {
+	Type      SearchResultType `json:"type"`
+	Highlight string           `json:"highlight"`
+	Snippet   *string          `json:"snippet"`
+	// Key is the id of the chip, brand or user
+	Key  string  `json:"-"`
+	Rank float64 `json:"-"`
+}
+
+type SearchConnection struct {
+	Edges    []*SearchEdge `json:"edges"`
+	PageInfo *PageInfo     `json:"pageInfo"`
+	// Query is the search the facets are counted for
+	Query string `json:"-"`
+}
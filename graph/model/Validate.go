@@ -7,10 +7,53 @@ import (
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 )
 
+var slugRegexp = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+// newChipSlugRegexp only checks the start of the slug, as NewChip always has.
+// It is kept for existing clients, new fields use slugRegexp.
+var newChipSlugRegexp = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*")
+
+// PasswordRules are the requirements for every password a user can set
+var PasswordRules = []validation.Rule{validation.Required, validation.Length(8, 128)}
+
 func (r NewReview) Validate() error {
 	return validation.ValidateStruct(&r,
 		validation.Field(&r.Rating, validation.Min(1), validation.Max(10)),
 		validation.Field(&r.Review, validation.Length(0, 10000)),
+		validation.Field(&r.Flavor),
+	)
+}
+
+func (f FlavorInput) Validate() error {
+	return validation.ValidateStruct(&f,
+		validation.Field(&f.Crunch, validation.NilOrNotEmpty, validation.Min(1), validation.Max(5)),
+		validation.Field(&f.Saltiness, validation.NilOrNotEmpty, validation.Min(1), validation.Max(5)),
+		validation.Field(&f.FlavorIntensity, validation.NilOrNotEmpty, validation.Min(1), validation.Max(5)),
+		validation.Field(&f.Greasiness, validation.NilOrNotEmpty, validation.Min(1), validation.Max(5)),
+		validation.Field(&f.Value, validation.NilOrNotEmpty, validation.Min(1), validation.Max(5)),
+	)
+}
+
+// CommentBody is the text of a new or edited comment. Unlike a review it is
+// required and shorter, see addComment in the schema.
+type CommentBody struct {
+	Body string
+}
+
+func (c CommentBody) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Body, validation.Required, validation.Length(1, 2000)),
+	)
+}
+
+// ReportReason is why content is reported
+type ReportReason struct {
+	Reason string
+}
+
+func (r ReportReason) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Reason, validation.Required, validation.Length(1, 1000)),
 	)
 }
 
@@ -18,7 +61,32 @@ func (c NewChip) Validate() error {
 	return validation.ValidateStruct(&c,
 		validation.Field(&c.Ingredients, validation.Length(0, 2000)),
 		validation.Field(&c.Name, validation.Required, validation.Length(1, 100)),
-		validation.Field(&c.Slug, validation.Match(regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*"))),
+		validation.Field(&c.Slug, validation.Match(newChipSlugRegexp)),
+		validation.Field(&c.Barcodes, validation.Each(isGTIN)),
+	)
+}
+
+func (c EditChip) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Brand, validation.NilOrNotEmpty),
+		validation.Field(&c.Category, validation.NilOrNotEmpty),
+		validation.Field(&c.Ingredients, validation.Length(0, 2000)),
+		validation.Field(&c.Name, validation.NilOrNotEmpty, validation.Length(1, 100)),
+		validation.Field(&c.Slug, validation.NilOrNotEmpty, validation.Match(slugRegexp)),
+		validation.Field(&c.Barcodes, validation.Each(isGTIN)),
+	)
+}
+
+func (b NewBrand) Validate() error {
+	return validation.ValidateStruct(&b,
+		validation.Field(&b.ID, validation.Required, validation.Length(1, 100), validation.Match(slugRegexp)),
+		validation.Field(&b.Name, validation.Required, validation.Length(1, 100)),
+	)
+}
+
+func (b EditBrand) Validate() error {
+	return validation.ValidateStruct(&b,
+		validation.Field(&b.Name, validation.NilOrNotEmpty, validation.Length(1, 100)),
 	)
 }
 
@@ -28,6 +96,13 @@ func (u NewUser) Validate() error {
 		validation.Field(&u.Username, validation.Match(regexp.MustCompile("^[a-zA-Z0-9-_]{2,20}$"))),
 		validation.Field(&u.Firstname, validation.Length(0, 35)),
 		validation.Field(&u.Lastname, validation.Length(0, 35)),
-		validation.Field(&u.Password, validation.Required, validation.Length(8, 128)),
+		validation.Field(&u.Password, PasswordRules...),
+	)
+}
+
+func (p ProfileInput) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Firstname, validation.Length(0, 35)),
+		validation.Field(&p.Lastname, validation.Length(0, 35)),
 	)
 }
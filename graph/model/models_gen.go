@@ -11,17 +11,74 @@ import (
 	"github.com/99designs/gqlgen/graphql"
 )
 
-type Chip struct {
-	ID          int     `json:"id"`
-	Brand       *Brand  `json:"brand"`
-	Category    string  `json:"category"`
-	Image       *string `json:"image"`
-	Ingredients *string `json:"ingredients"`
-	Name        string  `json:"name"`
-	Slug        string  `json:"slug"`
-	Subcategory *string `json:"subcategory"`
-	Rating      float64 `json:"rating"`
-	Reviews     int     `json:"reviews"`
+type ChipConnection struct {
+	Edges    []*ChipEdge `json:"edges"`
+	PageInfo *PageInfo   `json:"pageInfo"`
+}
+
+type ChipEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Chip  `json:"node"`
+}
+
+type CommentConnection struct {
+	Edges    []*CommentEdge `json:"edges"`
+	PageInfo *PageInfo      `json:"pageInfo"`
+}
+
+type CommentEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   *Comment `json:"node"`
+}
+
+type EditBrand struct {
+	Image *graphql.Upload `json:"image"`
+	Name  *string         `json:"name"`
+}
+
+type EditChip struct {
+	Brand       *string         `json:"brand"`
+	Category    *string         `json:"category"`
+	Image       *graphql.Upload `json:"image"`
+	Ingredients *string         `json:"ingredients"`
+	Name        *string         `json:"name"`
+	Slug        *string         `json:"slug"`
+	Subcategory *string         `json:"subcategory"`
+	Barcodes    []string        `json:"barcodes"`
+}
+
+type FlavorAverage struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+type FlavorInput struct {
+	Crunch          *int `json:"crunch"`
+	Saltiness       *int `json:"saltiness"`
+	FlavorIntensity *int `json:"flavorIntensity"`
+	Greasiness      *int `json:"greasiness"`
+	Value           *int `json:"value"`
+}
+
+type FlavorProfile struct {
+	Crunch          *FlavorAverage `json:"crunch"`
+	Saltiness       *FlavorAverage `json:"saltiness"`
+	FlavorIntensity *FlavorAverage `json:"flavorIntensity"`
+	Greasiness      *FlavorAverage `json:"greasiness"`
+	Value           *FlavorAverage `json:"value"`
+}
+
+type ImageSet struct {
+	Sm       *ImageVariant `json:"sm"`
+	Md       *ImageVariant `json:"md"`
+	Lg       *ImageVariant `json:"lg"`
+	Original string        `json:"original"`
+}
+
+type ImageVariant struct {
+	Size int    `json:"size"`
+	URL  string `json:"url"`
+	Webp string `json:"webp"`
 }
 
 type LoginResponse struct {
@@ -31,6 +88,22 @@ type LoginResponse struct {
 	Expires time.Time `json:"expires"`
 }
 
+type ModerationLogConnection struct {
+	Edges    []*ModerationLogEdge `json:"edges"`
+	PageInfo *PageInfo            `json:"pageInfo"`
+}
+
+type ModerationLogEdge struct {
+	Cursor string              `json:"cursor"`
+	Node   *ModerationLogEntry `json:"node"`
+}
+
+type NewBrand struct {
+	ID    string          `json:"id"`
+	Image *graphql.Upload `json:"image"`
+	Name  string          `json:"name"`
+}
+
 type NewChip struct {
 	Brand       string          `json:"brand"`
 	Category    string          `json:"category"`
@@ -39,12 +112,14 @@ type NewChip struct {
 	Name        string          `json:"name"`
 	Slug        string          `json:"slug"`
 	Subcategory *string         `json:"subcategory"`
+	Barcodes    []string        `json:"barcodes"`
 }
 
 type NewReview struct {
-	Chips  int     `json:"chips"`
-	Rating int     `json:"rating"`
-	Review *string `json:"review"`
+	Chips  int          `json:"chips"`
+	Rating int          `json:"rating"`
+	Review *string      `json:"review"`
+	Flavor *FlavorInput `json:"flavor"`
 }
 
 type NewUser struct {
@@ -58,16 +133,73 @@ type NewUser struct {
 	Token     string  `json:"token"`
 }
 
-type Review struct {
-	ID      int        `json:"id"`
-	Chips   *Chip      `json:"chips"`
-	Rating  *int       `json:"rating"`
-	Review  *string    `json:"review"`
-	User    *User      `json:"user"`
-	Created *time.Time `json:"created"`
-	Edited  *time.Time `json:"edited"`
-	Likes   *int       `json:"likes"`
-	Liked   *bool      `json:"liked"`
+type NotificationConnection struct {
+	Edges    []*NotificationEdge `json:"edges"`
+	PageInfo *PageInfo           `json:"pageInfo"`
+}
+
+type NotificationEdge struct {
+	Cursor string        `json:"cursor"`
+	Node   *Notification `json:"node"`
+}
+
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor"`
+	EndCursor       *string `json:"endCursor"`
+}
+
+type ProfileInput struct {
+	Firstname *string         `json:"firstname"`
+	Lastname  *string         `json:"lastname"`
+	Image     *graphql.Upload `json:"image"`
+}
+
+type ReportConnection struct {
+	Edges    []*ReportEdge `json:"edges"`
+	PageInfo *PageInfo     `json:"pageInfo"`
+}
+
+type ReportEdge struct {
+	Cursor string  `json:"cursor"`
+	Node   *Report `json:"node"`
+}
+
+type ReviewConnection struct {
+	Edges    []*ReviewEdge `json:"edges"`
+	PageInfo *PageInfo     `json:"pageInfo"`
+}
+
+type ReviewEdge struct {
+	Cursor string  `json:"cursor"`
+	Node   *Review `json:"node"`
+}
+
+type ReviewRevision struct {
+	ID       int       `json:"id"`
+	Rating   int       `json:"rating"`
+	Review   *string   `json:"review"`
+	Flavor   *Flavor   `json:"flavor"`
+	Created  time.Time `json:"created"`
+	Replaced time.Time `json:"replaced"`
+}
+
+type SearchEdge struct {
+	Cursor string     `json:"cursor"`
+	Node   *SearchHit `json:"node"`
+}
+
+type SearchFacet struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+type SearchFacets struct {
+	Types      []*SearchFacet `json:"types"`
+	Categories []*SearchFacet `json:"categories"`
+	Brands     []*SearchFacet `json:"brands"`
 }
 
 type SearchResponse struct {
@@ -75,6 +207,25 @@ type SearchResponse struct {
 	Chips []*Chip `json:"chips"`
 }
 
+type Session struct {
+	ID        int       `json:"id"`
+	Device    *string   `json:"device"`
+	UserAgent *string   `json:"userAgent"`
+	IP        *string   `json:"ip"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"lastUsed"`
+	Current   bool      `json:"current"`
+}
+
+type Suggestion struct {
+	Type   SearchResultType `json:"type"`
+	ID     string           `json:"id"`
+	Label  string           `json:"label"`
+	Detail *string          `json:"detail"`
+	Brand  *string          `json:"brand"`
+	Slug   *string          `json:"slug"`
+}
+
 type User struct {
 	ID        int        `json:"id"`
 	Username  *string    `json:"username"`
@@ -129,20 +280,40 @@ func (e BrandSortByInput) MarshalGQL(w io.Writer) {
 type ChipSortByInput string
 
 const (
-	ChipSortByInputNameAsc    ChipSortByInput = "NAME_ASC"
-	ChipSortByInputRatingDesc ChipSortByInput = "RATING_DESC"
-	ChipSortByInputTop        ChipSortByInput = "TOP"
+	ChipSortByInputNameAsc             ChipSortByInput = "NAME_ASC"
+	ChipSortByInputRatingDesc          ChipSortByInput = "RATING_DESC"
+	ChipSortByInputTop                 ChipSortByInput = "TOP"
+	ChipSortByInputCrunchAsc           ChipSortByInput = "CRUNCH_ASC"
+	ChipSortByInputCrunchDesc          ChipSortByInput = "CRUNCH_DESC"
+	ChipSortByInputSaltinessAsc        ChipSortByInput = "SALTINESS_ASC"
+	ChipSortByInputSaltinessDesc       ChipSortByInput = "SALTINESS_DESC"
+	ChipSortByInputFlavorIntensityAsc  ChipSortByInput = "FLAVOR_INTENSITY_ASC"
+	ChipSortByInputFlavorIntensityDesc ChipSortByInput = "FLAVOR_INTENSITY_DESC"
+	ChipSortByInputGreasinessAsc       ChipSortByInput = "GREASINESS_ASC"
+	ChipSortByInputGreasinessDesc      ChipSortByInput = "GREASINESS_DESC"
+	ChipSortByInputValueAsc            ChipSortByInput = "VALUE_ASC"
+	ChipSortByInputValueDesc           ChipSortByInput = "VALUE_DESC"
 )
 
 var AllChipSortByInput = []ChipSortByInput{
 	ChipSortByInputNameAsc,
 	ChipSortByInputRatingDesc,
 	ChipSortByInputTop,
+	ChipSortByInputCrunchAsc,
+	ChipSortByInputCrunchDesc,
+	ChipSortByInputSaltinessAsc,
+	ChipSortByInputSaltinessDesc,
+	ChipSortByInputFlavorIntensityAsc,
+	ChipSortByInputFlavorIntensityDesc,
+	ChipSortByInputGreasinessAsc,
+	ChipSortByInputGreasinessDesc,
+	ChipSortByInputValueAsc,
+	ChipSortByInputValueDesc,
 }
 
 func (e ChipSortByInput) IsValid() bool {
 	switch e {
-	case ChipSortByInputNameAsc, ChipSortByInputRatingDesc, ChipSortByInputTop:
+	case ChipSortByInputNameAsc, ChipSortByInputRatingDesc, ChipSortByInputTop, ChipSortByInputCrunchAsc, ChipSortByInputCrunchDesc, ChipSortByInputSaltinessAsc, ChipSortByInputSaltinessDesc, ChipSortByInputFlavorIntensityAsc, ChipSortByInputFlavorIntensityDesc, ChipSortByInputGreasinessAsc, ChipSortByInputGreasinessDesc, ChipSortByInputValueAsc, ChipSortByInputValueDesc:
 		return true
 	}
 	return false
@@ -169,6 +340,227 @@ func (e ChipSortByInput) MarshalGQL(w io.Writer) {
 	fmt.Fprint(w, strconv.Quote(e.String()))
 }
 
+type ContentType string
+
+const (
+	ContentTypeReview  ContentType = "REVIEW"
+	ContentTypeComment ContentType = "COMMENT"
+	ContentTypeUser    ContentType = "USER"
+)
+
+var AllContentType = []ContentType{
+	ContentTypeReview,
+	ContentTypeComment,
+	ContentTypeUser,
+}
+
+func (e ContentType) IsValid() bool {
+	switch e {
+	case ContentTypeReview, ContentTypeComment, ContentTypeUser:
+		return true
+	}
+	return false
+}
+
+func (e ContentType) String() string {
+	return string(e)
+}
+
+func (e *ContentType) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ContentType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ContentType", str)
+	}
+	return nil
+}
+
+func (e ContentType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ImageStatus string
+
+const (
+	ImageStatusPending ImageStatus = "PENDING"
+	ImageStatusReady   ImageStatus = "READY"
+	ImageStatusFailed  ImageStatus = "FAILED"
+)
+
+var AllImageStatus = []ImageStatus{
+	ImageStatusPending,
+	ImageStatusReady,
+	ImageStatusFailed,
+}
+
+func (e ImageStatus) IsValid() bool {
+	switch e {
+	case ImageStatusPending, ImageStatusReady, ImageStatusFailed:
+		return true
+	}
+	return false
+}
+
+func (e ImageStatus) String() string {
+	return string(e)
+}
+
+func (e *ImageStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ImageStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ImageStatus", str)
+	}
+	return nil
+}
+
+func (e ImageStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ModerationAction string
+
+const (
+	ModerationActionDismiss ModerationAction = "DISMISS"
+	ModerationActionHide    ModerationAction = "HIDE"
+	ModerationActionDelete  ModerationAction = "DELETE"
+	ModerationActionWarn    ModerationAction = "WARN"
+	ModerationActionBan     ModerationAction = "BAN"
+)
+
+var AllModerationAction = []ModerationAction{
+	ModerationActionDismiss,
+	ModerationActionHide,
+	ModerationActionDelete,
+	ModerationActionWarn,
+	ModerationActionBan,
+}
+
+func (e ModerationAction) IsValid() bool {
+	switch e {
+	case ModerationActionDismiss, ModerationActionHide, ModerationActionDelete, ModerationActionWarn, ModerationActionBan:
+		return true
+	}
+	return false
+}
+
+func (e ModerationAction) String() string {
+	return string(e)
+}
+
+func (e *ModerationAction) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ModerationAction(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ModerationAction", str)
+	}
+	return nil
+}
+
+func (e ModerationAction) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type NotificationKind string
+
+const (
+	NotificationKindLike   NotificationKind = "LIKE"
+	NotificationKindFollow NotificationKind = "FOLLOW"
+	NotificationKindReview NotificationKind = "REVIEW"
+)
+
+var AllNotificationKind = []NotificationKind{
+	NotificationKindLike,
+	NotificationKindFollow,
+	NotificationKindReview,
+}
+
+func (e NotificationKind) IsValid() bool {
+	switch e {
+	case NotificationKindLike, NotificationKindFollow, NotificationKindReview:
+		return true
+	}
+	return false
+}
+
+func (e NotificationKind) String() string {
+	return string(e)
+}
+
+func (e *NotificationKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = NotificationKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid NotificationKind", str)
+	}
+	return nil
+}
+
+func (e NotificationKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Permission string
+
+const (
+	PermissionManageCatalog   Permission = "MANAGE_CATALOG"
+	PermissionModerateContent Permission = "MODERATE_CONTENT"
+	PermissionBanUsers        Permission = "BAN_USERS"
+	PermissionManageRoles     Permission = "MANAGE_ROLES"
+)
+
+var AllPermission = []Permission{
+	PermissionManageCatalog,
+	PermissionModerateContent,
+	PermissionBanUsers,
+	PermissionManageRoles,
+}
+
+func (e Permission) IsValid() bool {
+	switch e {
+	case PermissionManageCatalog, PermissionModerateContent, PermissionBanUsers, PermissionManageRoles:
+		return true
+	}
+	return false
+}
+
+func (e Permission) String() string {
+	return string(e)
+}
+
+func (e *Permission) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Permission(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Permission", str)
+	}
+	return nil
+}
+
+func (e Permission) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
 type ReviewSortByInput string
 
 const (
@@ -207,3 +599,89 @@ func (e *ReviewSortByInput) UnmarshalGQL(v interface{}) error {
 func (e ReviewSortByInput) MarshalGQL(w io.Writer) {
 	fmt.Fprint(w, strconv.Quote(e.String()))
 }
+
+type Role string
+
+const (
+	RoleUser      Role = "USER"
+	RoleModerator Role = "MODERATOR"
+	RoleAdmin     Role = "ADMIN"
+)
+
+var AllRole = []Role{
+	RoleUser,
+	RoleModerator,
+	RoleAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type SearchResultType string
+
+const (
+	SearchResultTypeChip  SearchResultType = "CHIP"
+	SearchResultTypeBrand SearchResultType = "BRAND"
+	SearchResultTypeUser  SearchResultType = "USER"
+)
+
+var AllSearchResultType = []SearchResultType{
+	SearchResultTypeChip,
+	SearchResultTypeBrand,
+	SearchResultTypeUser,
+}
+
+func (e SearchResultType) IsValid() bool {
+	switch e {
+	case SearchResultTypeChip, SearchResultTypeBrand, SearchResultTypeUser:
+		return true
+	}
+	return false
+}
+
+func (e SearchResultType) String() string {
+	return string(e)
+}
+
+func (e *SearchResultType) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = SearchResultType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid SearchResultType", str)
+	}
+	return nil
+}
+
+func (e SearchResultType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
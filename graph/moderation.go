@@ -0,0 +1,371 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/c-wiren/snackstoppen-backend/apperr"
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/c-wiren/snackstoppen-backend/jobs"
+	"github.com/c-wiren/snackstoppen-backend/mail"
+	"github.com/c-wiren/snackstoppen-backend/pubsub"
+	"github.com/c-wiren/snackstoppen-backend/stats"
+	"github.com/jackc/pgx/v4"
+)
+
+// reportedContent selects the author and text of content that can be
+// reported, with the id as $1
+var reportedContent = map[model.ContentType]string{
+	model.ContentTypeReview:  `SELECT user_id, review FROM reviews WHERE id=$1 AND hidden IS NULL`,
+	model.ContentTypeComment: `SELECT user_id, body FROM comments WHERE id=$1 AND deleted IS NULL AND hidden IS NULL`,
+	model.ContentTypeUser:    `SELECT id, username FROM users WHERE id=$1`,
+}
+
+// unknownContent is returned when reported content does not exist
+var unknownContent = map[model.ContentType]*apperr.Error{
+	model.ContentTypeReview:  apperr.New(apperr.UnknownReview, "Review does not exist"),
+	model.ContentTypeComment: apperr.New(apperr.UnknownComment, "Comment does not exist"),
+	model.ContentTypeUser:    apperr.New(apperr.UnknownUser, "User does not exist"),
+}
+
+// contentNames are the Swedish names of content in warnings
+var contentNames = map[string]string{
+	"review":  "recension",
+	"comment": "kommentar",
+	"user":    "profil",
+}
+
+// reportContent adds a report, reporting the same content again before it is
+// resolved does nothing
+func (r *Resolver) reportContent(ctx context.Context, reporterID int, contentType model.ContentType, id int, reason string) error {
+	var userID int
+	var content *string
+	err := r.DB.QueryRow(ctx, reportedContent[contentType], id).Scan(&userID, &content)
+	if err == pgx.ErrNoRows {
+		return unknownContent[contentType]
+	}
+	if err != nil {
+		return apperr.InternalError(err, "reported content query failed")
+	}
+	_, err = r.DB.Exec(ctx, `INSERT INTO reports (reporter_id, content_type, content_id, content, user_id, reason)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT DO NOTHING`, reporterID, strings.ToLower(string(contentType)), id, content, userID, reason)
+	if err != nil {
+		return apperr.InternalError(err, "insert report failed")
+	}
+	return nil
+}
+
+// moderation is a moderation action on a review, comment or user
+type moderation struct {
+	actorID     int
+	action      model.ModerationAction
+	contentType string
+	contentID   int
+	// userID is the user or the author of the review or comment
+	userID      *int
+	reportID    *int
+	note        *string
+	bannedUntil *time.Time
+}
+
+// resolveReport acts on the content of a report
+func (r *Resolver) resolveReport(ctx context.Context, m *moderation, id int) (*model.Report, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	report := &model.Report{}
+	err = tx.QueryRow(ctx, `SELECT `+model.ReportColumns+` FROM reports WHERE id=$1 FOR UPDATE`, id).Scan(report.Fields()...)
+	if err == pgx.ErrNoRows {
+		return nil, apperr.New(apperr.UnknownReport, "Report does not exist")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "report query failed")
+	}
+	if report.Resolved != nil {
+		return nil, apperr.New(apperr.ReportResolved, "Report is already resolved")
+	}
+	m.contentType, m.contentID, m.userID, m.reportID = report.Type, report.ContentID, report.UserID, &report.ID
+	err = r.moderate(ctx, tx, m)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRow(ctx, `SELECT `+model.ReportColumns+` FROM reports WHERE id=$1`, id).Scan(report.Fields()...)
+	if err != nil {
+		return nil, apperr.InternalError(err, "report query failed")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit report resolve failed")
+	}
+	return report, nil
+}
+
+// moderate acts on content, resolves every report of it and logs the action
+func (r *Resolver) moderate(ctx context.Context, tx pgx.Tx, m *moderation) error {
+	var err error
+	switch m.action {
+	case model.ModerationActionHide:
+		err = hideContent(ctx, tx, m)
+	case model.ModerationActionDelete:
+		err = deleteContent(ctx, tx, m)
+	case model.ModerationActionWarn:
+		err = r.warnUser(ctx, tx, m)
+	case model.ModerationActionBan:
+		err = banUser(ctx, tx, m)
+	}
+	if err != nil {
+		return err
+	}
+
+	action := strings.ToLower(string(m.action))
+	_, err = tx.Exec(ctx, `UPDATE reports SET resolved=NOW(), action=$1
+	WHERE content_type=$2 AND content_id=$3 AND resolved IS NULL`, action, m.contentType, m.contentID)
+	if err != nil {
+		return apperr.InternalError(err, "resolve reports failed")
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO moderation_log (actor_id, action, content_type, content_id, user_id, report_id, note)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`, m.actorID, action, m.contentType, m.contentID, m.userID, m.reportID, m.note)
+	if err != nil {
+		return apperr.InternalError(err, "insert moderation log failed")
+	}
+	return nil
+}
+
+// errAlreadyHidden is returned when hiding content that is already hidden or
+// has been deleted
+var errAlreadyHidden = apperr.New(apperr.AlreadyHidden, "Content is already hidden or deleted")
+
+// hideContent hides a review or comment
+func hideContent(ctx context.Context, tx pgx.Tx, m *moderation) error {
+	switch m.contentType {
+	case "review":
+		review := &model.Review{}
+		err := tx.QueryRow(ctx, `UPDATE reviews SET hidden=NOW()
+		WHERE id=$1 AND hidden IS NULL
+		RETURNING `+model.ReviewColumns, m.contentID).Scan(review.Fields()...)
+		if err == pgx.ErrNoRows {
+			return errAlreadyHidden
+		}
+		if err != nil {
+			return apperr.InternalError(err, "hide review failed")
+		}
+		err = stats.RemoveReview(ctx, tx, review.ChipsID, reviewScores(review))
+		if err != nil {
+			return apperr.InternalError(err, "update chip stats failed")
+		}
+	case "comment":
+		commandTag, err := tx.Exec(ctx, `UPDATE comments SET hidden=NOW()
+		WHERE id=$1 AND hidden IS NULL AND deleted IS NULL`, m.contentID)
+		if err != nil {
+			return apperr.InternalError(err, "hide comment failed")
+		}
+		if commandTag.RowsAffected() != 1 {
+			return errAlreadyHidden
+		}
+	default:
+		return apperr.New(apperr.UserInput, "Users can not be hidden, ban them instead")
+	}
+	return nil
+}
+
+// deleteContent deletes a review or removes the body of a comment like its
+// author would. It fails if the content is already gone, so that the report
+// stays open and no deletion is logged.
+func deleteContent(ctx context.Context, tx pgx.Tx, m *moderation) error {
+	switch m.contentType {
+	case "review":
+		review := &model.Review{}
+		err := tx.QueryRow(ctx, `DELETE FROM reviews WHERE id=$1
+		RETURNING `+model.ReviewColumns, m.contentID).Scan(review.Fields()...)
+		if err == pgx.ErrNoRows {
+			return apperr.New(apperr.NotFound, "Review does not exist")
+		}
+		if err != nil {
+			return apperr.InternalError(err, "delete review failed")
+		}
+		if !review.Hidden {
+			err = stats.RemoveReview(ctx, tx, review.ChipsID, reviewScores(review))
+			if err != nil {
+				return apperr.InternalError(err, "update chip stats failed")
+			}
+		}
+	case "comment":
+		commandTag, err := tx.Exec(ctx, `UPDATE comments SET body=NULL, deleted=NOW() WHERE id=$1 AND deleted IS NULL`, m.contentID)
+		if err != nil {
+			return apperr.InternalError(err, "delete comment failed")
+		}
+		if commandTag.RowsAffected() != 1 {
+			return apperr.New(apperr.NotFound, "Comment does not exist or is already deleted")
+		}
+	default:
+		return apperr.New(apperr.UserInput, "Users can not be deleted, ban them instead")
+	}
+	return nil
+}
+
+// warningJob emails a warning to a user after a moderation action commits
+type warningJob struct {
+	UserID      int     `json:"user"`
+	ContentType string  `json:"content_type"`
+	Note        *string `json:"note,omitempty"`
+}
+
+const warningJobKind = "warning"
+
+// warnUser queues an email warning the user, which is only sent if the
+// moderation action commits
+func (r *Resolver) warnUser(ctx context.Context, tx pgx.Tx, m *moderation) error {
+	if m.userID == nil {
+		return apperr.New(apperr.UnknownUser, "User does not exist")
+	}
+	var exists bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id=$1)`, *m.userID).Scan(&exists)
+	if err != nil {
+		return apperr.InternalError(err, "user query failed")
+	}
+	if !exists {
+		return apperr.New(apperr.UnknownUser, "User does not exist")
+	}
+	err = jobs.Enqueue(ctx, tx, warningJobKind, warningJob{UserID: *m.userID, ContentType: m.contentType, Note: m.note})
+	if err != nil {
+		return apperr.InternalError(err, "queue warning failed")
+	}
+	return nil
+}
+
+// sendWarning runs a warningJob
+func (r *Resolver) sendWarning(ctx context.Context, job *jobs.Job) error {
+	var payload warningJob
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return err
+	}
+	var email string
+	var username *string
+	err = r.DB.QueryRow(ctx, `SELECT email, username FROM users WHERE id=$1`, payload.UserID).Scan(&email, &username)
+	if err == pgx.ErrNoRows {
+		// The user was deleted before the warning was sent
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	greeting := "Hej!"
+	if username != nil {
+		greeting = fmt.Sprintf("Hej %s!", html.EscapeString(*username))
+	}
+	body := fmt.Sprintf("<p>%s</p><p>Din %s på Snackstoppen har anmälts och bryter mot våra regler.</p>",
+		greeting, contentNames[payload.ContentType])
+	if payload.Note != nil {
+		body += fmt.Sprintf("<p>%s</p>", html.EscapeString(*payload.Note))
+	}
+	body += "<p>Om det händer igen kan ditt konto stängas av.</p><p>Hälsningar,<br>Snackstoppen</p>"
+	return r.Mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Varning från Snackstoppen",
+		HTML:    body,
+	})
+}
+
+// banUser bans the user until m.bannedUntil or forever and logs out every
+// session and websocket connection
+func banUser(ctx context.Context, tx pgx.Tx, m *moderation) error {
+	if m.userID == nil {
+		return apperr.New(apperr.UnknownUser, "User does not exist")
+	}
+	if *m.userID == m.actorID {
+		return apperr.New(apperr.Forbidden, "You can not ban yourself")
+	}
+	if m.bannedUntil != nil && !m.bannedUntil.After(time.Now()) {
+		return apperr.New(apperr.UserInput, "The ban must end in the future")
+	}
+	err := checkAdminRemains(ctx, tx, *m.userID)
+	if err != nil {
+		return err
+	}
+	commandTag, err := tx.Exec(ctx, `UPDATE users SET banned_until=COALESCE($2, 'infinity') WHERE id=$1`, *m.userID, m.bannedUntil)
+	if err != nil {
+		return apperr.InternalError(err, "ban user failed")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return apperr.New(apperr.UnknownUser, "User does not exist")
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM sessions WHERE user_id=$1`, *m.userID)
+	if err != nil {
+		return apperr.InternalError(err, "db sessions not removed")
+	}
+	// Closes the websocket connections of the user
+	err = pubsub.Publish(ctx, tx, pubsub.UsersTopic(*m.userID), *m.userID)
+	if err != nil {
+		return apperr.InternalError(err, "publish ban failed")
+	}
+	return nil
+}
+
+// checkAdminRemains fails if the user is the last admin that is not banned.
+// The admins are locked, so that concurrent changes can not remove them all.
+func checkAdminRemains(ctx context.Context, tx pgx.Tx, userID int) error {
+	rows, err := tx.Query(ctx, `SELECT id FROM users
+	WHERE role='admin' AND (banned_until IS NULL OR banned_until <= NOW())
+	FOR UPDATE`)
+	if err != nil {
+		return apperr.InternalError(err, "admin query failed")
+	}
+	defer rows.Close()
+	isAdmin, others := false, 0
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return apperr.InternalError(err, "admin scan failed")
+		}
+		if id == userID {
+			isAdmin = true
+		} else {
+			others++
+		}
+	}
+	if rows.Err() != nil {
+		return apperr.InternalError(rows.Err(), "admin query failed")
+	}
+	if isAdmin && others == 0 {
+		return apperr.New(apperr.LastAdmin, "There must be at least one admin")
+	}
+	return nil
+}
+
+// reportedReview returns a review even if it is hidden
+func (r *Resolver) reportedReview(ctx context.Context, id int) (*model.Review, error) {
+	review := &model.Review{}
+	err := r.DB.QueryRow(ctx, `SELECT `+model.ReviewColumns+`, false FROM reviews WHERE id=$1`, id).Scan(append(review.Fields(), &review.Liked)...)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "review query failed")
+	}
+	return review, nil
+}
+
+// reportedComment returns a comment as it is shown to users
+func (r *Resolver) reportedComment(ctx context.Context, id int) (*model.Comment, error) {
+	comment := &model.Comment{}
+	err := r.DB.QueryRow(ctx, `SELECT `+model.CommentColumns+` FROM comments WHERE id=$1`, id).Scan(comment.Fields()...)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "comment query failed")
+	}
+	return comment, nil
+}
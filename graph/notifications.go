@@ -0,0 +1,58 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/c-wiren/snackstoppen-backend/apperr"
+	"github.com/c-wiren/snackstoppen-backend/dataloader"
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/c-wiren/snackstoppen-backend/notifications"
+)
+
+// maxNotificationActors limits the actors returned for a notification
+const maxNotificationActors = 3
+
+// notificationActors loads the latest actors of a notification, users that
+// have been deleted are left out
+func notificationActors(ctx context.Context, notification *model.Notification) ([]*model.User, error) {
+	actors := []*model.User{}
+	for _, id := range notification.ActorIDs {
+		if len(actors) == maxNotificationActors {
+			break
+		}
+		user, err := dataloader.For(ctx).User(id)
+		if err != nil {
+			return nil, apperr.InternalError(err, "load user failed")
+		}
+		if user != nil {
+			actors = append(actors, user)
+		}
+	}
+	return actors, nil
+}
+
+// notificationMessage describes a notification, e.g. "Anna och 4 andra
+// gillade din recension"
+func notificationMessage(kind string, actors []*model.User, count int) string {
+	who := "Någon"
+	if len(actors) > 0 && actors[0].Username != nil {
+		who = *actors[0].Username
+	}
+	switch {
+	case count == 2 && len(actors) == 2 && actors[1].Username != nil:
+		who += " och " + *actors[1].Username
+	case count == 2:
+		who += " och 1 annan"
+	case count > 2:
+		who += fmt.Sprintf(" och %d andra", count-1)
+	}
+	switch kind {
+	case notifications.Like:
+		return who + " gillade din recension"
+	case notifications.Follow:
+		return who + " började följa dig"
+	default:
+		return who + " skrev en recension"
+	}
+}
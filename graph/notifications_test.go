@@ -0,0 +1,42 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/c-wiren/snackstoppen-backend/notifications"
+)
+
+func TestNotificationMessage(t *testing.T) {
+	user := func(username string) *model.User {
+		return &model.User{Username: &username}
+	}
+	anna, erik := user("anna"), user("erik")
+	deleted := &model.User{}
+	tests := []struct {
+		kind   string
+		actors []*model.User
+		count  int
+		want   string
+	}{
+		{notifications.Like, []*model.User{anna}, 1, "anna gillade din recension"},
+		{notifications.Follow, []*model.User{anna}, 1, "anna började följa dig"},
+		{notifications.Review, []*model.User{anna}, 1, "anna skrev en recension"},
+		// Actors without a username
+		{notifications.Like, nil, 1, "Någon gillade din recension"},
+		{notifications.Like, []*model.User{deleted}, 1, "Någon gillade din recension"},
+		// Two actors are both named
+		{notifications.Like, []*model.User{anna, erik}, 2, "anna och erik gillade din recension"},
+		{notifications.Like, []*model.User{anna}, 2, "anna och 1 annan gillade din recension"},
+		{notifications.Like, []*model.User{anna, deleted}, 2, "anna och 1 annan gillade din recension"},
+		// More actors are counted
+		{notifications.Like, []*model.User{anna, erik}, 3, "anna och 2 andra gillade din recension"},
+		{notifications.Follow, []*model.User{anna, erik}, 10, "anna och 9 andra började följa dig"},
+		{notifications.Follow, nil, 3, "Någon och 2 andra började följa dig"},
+	}
+	for _, test := range tests {
+		if got := notificationMessage(test.kind, test.actors, test.count); got != test.want {
+			t.Errorf("notificationMessage(%q, %d actors, %d) = %q, want %q", test.kind, len(test.actors), test.count, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package graph
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c-wiren/snackstoppen-backend/apperr"
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/c-wiren/snackstoppen-backend/stats"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const maxPageSize = 100
+
+var errInvalidCursor = apperr.New(apperr.UserInput, "Invalid cursor")
+
+// pageSize checks the first argument of a connection
+func pageSize(first *int, defaultSize int) (int, error) {
+	if first == nil {
+		return defaultSize, nil
+	}
+	if *first < 0 || *first > maxPageSize {
+		return 0, apperr.New(apperr.UserInput, fmt.Sprintf("first must be between 0 and %d", maxPageSize))
+	}
+	return *first, nil
+}
+
+// encodeCursor packs the sort key of a row into an opaque cursor
+func encodeCursor(values ...interface{}) string {
+	b, _ := json.Marshal(values)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor unpacks a cursor created by encodeCursor into dst
+func decodeCursor(cursor string, dst ...interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return errInvalidCursor
+	}
+	var values []json.RawMessage
+	err = json.Unmarshal(b, &values)
+	if err != nil || len(values) != len(dst) {
+		return errInvalidCursor
+	}
+	for i, value := range values {
+		if json.Unmarshal(value, dst[i]) != nil {
+			return errInvalidCursor
+		}
+	}
+	return nil
+}
+
+func newPageInfo(startCursor *string, endCursor *string, hasNextPage bool, after *string) *model.PageInfo {
+	return &model.PageInfo{
+		HasNextPage:     hasNextPage,
+		HasPreviousPage: after != nil,
+		StartCursor:     startCursor,
+		EndCursor:       endCursor,
+	}
+}
+
+// queryPage runs the query of a connection, which fetched one extra row to
+// know if there is a next page, and returns the edges of the other rows. scan
+// reads a row into an edge and cursor returns the cursor of an edge.
+func queryPage[E any](ctx context.Context, db *pgxpool.Pool, q string, args queryArgs, first int, after *string,
+	scan func(rows pgx.Rows) (E, error), cursor func(edge E) string) ([]E, *model.PageInfo, error) {
+	rows, err := db.Query(ctx, q, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	edges := []E{}
+	hasNextPage := false
+	for rows.Next() {
+		if len(edges) == first {
+			hasNextPage = true
+			break
+		}
+		edge, err := scan(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		edges = append(edges, edge)
+	}
+	if rows.Err() != nil {
+		return nil, nil, rows.Err()
+	}
+	pageInfo := newPageInfo(nil, nil, hasNextPage, after)
+	if len(edges) > 0 {
+		start, end := cursor(edges[0]), cursor(edges[len(edges)-1])
+		pageInfo.StartCursor, pageInfo.EndCursor = &start, &end
+	}
+	return edges, pageInfo, nil
+}
+
+// queryArgs collects the arguments of a query and returns their placeholders
+type queryArgs []interface{}
+
+func (a *queryArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprint("$", len(*a))
+}
+
+// chipFilter builds the WHERE clause shared by chips and chipsConnection
+func chipFilter(args *queryArgs, brand *string, category *string, subcategory []*string, orderBy *model.ChipSortByInput) []string {
+	var where []string
+	if brand != nil {
+		where = append(where, "chips.brand_id="+args.add(brand))
+	}
+	if category != nil {
+		where = append(where, "chips.category="+args.add(category))
+	}
+	if len(subcategory) > 0 {
+		var placeholders []string
+		for _, subcat := range subcategory {
+			placeholders = append(placeholders, args.add(subcat))
+		}
+		where = append(where, "chips.subcategory IN ("+strings.Join(placeholders, ",")+")")
+	}
+	if orderBy != nil {
+		if sort, ok := numericChipSort(*orderBy); ok && sort.filter != "" {
+			where = append(where, sort.filter)
+		}
+	}
+	return where
+}
+
+// chipSort is a numeric sort order of chips, ties are broken by name and id
+type chipSort struct {
+	// column is the SQL expression that is sorted by
+	column string
+	// value returns the column of a scanned chip
+	value func(chip *model.Chip) float64
+	desc  bool
+	// filter leaves out chips without a value
+	filter string
+}
+
+// numericChipSort returns the sort order of every order_by except NAME_ASC
+func numericChipSort(orderBy model.ChipSortByInput) (chipSort, bool) {
+	switch orderBy {
+	case model.ChipSortByInputRatingDesc:
+		return chipSort{
+			column: "chips.rating",
+			value:  func(chip *model.Chip) float64 { return chip.Rating },
+			desc:   true,
+		}, true
+	case model.ChipSortByInputTop:
+		return chipSort{
+			column: "chips.score",
+			value:  func(chip *model.Chip) float64 { return *chip.Score },
+			desc:   true,
+			filter: "chips.score IS NOT NULL",
+		}, true
+	}
+	// Flavor sub-scores sort by the average, e.g. CRUNCH_DESC
+	for i, dimension := range stats.FlavorDimensions {
+		i := i
+		name := strings.ToUpper(dimension)
+		if orderBy != model.ChipSortByInput(name+"_ASC") && orderBy != model.ChipSortByInput(name+"_DESC") {
+			continue
+		}
+		return chipSort{
+			column: fmt.Sprintf("(chips.%[1]s_sum::double precision / NULLIF(chips.%[1]s_count, 0))", dimension),
+			value: func(chip *model.Chip) float64 {
+				return float64(chip.FlavorSums[i]) / float64(chip.FlavorCounts[i])
+			},
+			desc:   orderBy == model.ChipSortByInput(name+"_DESC"),
+			filter: fmt.Sprintf("chips.%s_count > 0", dimension),
+		}, true
+	}
+	return chipSort{}, false
+}
+
+// reviewConnection pages through reviews newest first. The query must select
+// model.ReviewColumns and liked, and end with a WHERE clause.
+func (r *queryResolver) reviewConnection(ctx context.Context, q string, args queryArgs, first int, after *string) (*model.ReviewConnection, error) {
+	q += " AND " + visibleReviews
+	if after != nil {
+		var created time.Time
+		var id int
+		if decodeCursor(*after, &created, &id) != nil {
+			return nil, errInvalidCursor
+		}
+		q += fmt.Sprintf(" AND (reviews.created, reviews.id) < (%s, %s)", args.add(created), args.add(id))
+	}
+	// Fetch one extra row to know if there is a next page
+	q += " ORDER BY reviews.created DESC, reviews.id DESC LIMIT " + args.add(first+1)
+
+	edges, pageInfo, err := queryPage(ctx, r.DB, q, args, first, after, func(rows pgx.Rows) (*model.ReviewEdge, error) {
+		review := &model.Review{}
+		err := rows.Scan(append(review.Fields(), &review.Liked)...)
+		if err != nil {
+			return nil, err
+		}
+		if review.Created == nil {
+			return nil, errors.New("review without created date")
+		}
+		return &model.ReviewEdge{Cursor: encodeCursor(review.Created, review.ID), Node: review}, nil
+	}, func(edge *model.ReviewEdge) string { return edge.Cursor })
+	if err != nil {
+		return nil, apperr.InternalError(err, "reviews connection query failed")
+	}
+	return &model.ReviewConnection{Edges: edges, PageInfo: pageInfo}, nil
+}
+
+// commentConnection pages through comments oldest first. The query must
+// select model.CommentColumns and end with a WHERE clause.
+func (r *Resolver) commentConnection(ctx context.Context, q string, args queryArgs, first int, after *string) (*model.CommentConnection, error) {
+	if after != nil {
+		var created time.Time
+		var id int
+		if decodeCursor(*after, &created, &id) != nil {
+			return nil, errInvalidCursor
+		}
+		q += fmt.Sprintf(" AND (comments.created, comments.id) > (%s, %s)", args.add(created), args.add(id))
+	}
+	// Fetch one extra row to know if there is a next page
+	q += " ORDER BY comments.created, comments.id LIMIT " + args.add(first+1)
+
+	edges, pageInfo, err := queryPage(ctx, r.DB, q, args, first, after, func(rows pgx.Rows) (*model.CommentEdge, error) {
+		comment := &model.Comment{}
+		err := rows.Scan(comment.Fields()...)
+		return &model.CommentEdge{Cursor: encodeCursor(comment.Created, comment.ID), Node: comment}, err
+	}, func(edge *model.CommentEdge) string { return edge.Cursor })
+	if err != nil {
+		return nil, apperr.InternalError(err, "comments connection query failed")
+	}
+	return &model.CommentConnection{Edges: edges, PageInfo: pageInfo}, nil
+}
+
+// likedColumn is whether the current user liked a review, the query must
+// left join their likes
+const likedColumn = `likes.user_id IS NOT NULL AS liked`
+
+// visibleReviews leaves out hidden reviews, they are only shown to moderators
+const visibleReviews = `reviews.hidden IS NULL`
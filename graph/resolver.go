@@ -1,9 +1,12 @@
 package graph
 
 import (
+	"github.com/c-wiren/snackstoppen-backend/jobs"
+	"github.com/c-wiren/snackstoppen-backend/mail"
+	"github.com/c-wiren/snackstoppen-backend/pubsub"
+	"github.com/c-wiren/snackstoppen-backend/storage"
+	"github.com/c-wiren/snackstoppen-backend/suggest"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/mailgun/mailgun-go/v4"
-	"github.com/minio/minio-go/v7"
 )
 
 //go:generate go run github.com/99designs/gqlgen
@@ -13,7 +16,17 @@ import (
 // It serves as dependency injection for your app, add any dependencies you require here.
 
 type Resolver struct {
-	DB      *pgxpool.Pool
-	Mailgun *mailgun.MailgunImpl
-	S3      *minio.Client
+	DB     *pgxpool.Pool
+	Mailer mail.Mailer
+	Images storage.ImageStore
+	// Suggestions is rebuilt when chips, brands or users change
+	Suggestions *suggest.Index
+	// PubSub delivers the events of subscriptions
+	PubSub *pubsub.Broker
+}
+
+// RegisterJobs adds the handlers of background jobs to the queue
+func (r *Resolver) RegisterJobs(queue *jobs.Queue) {
+	queue.Handle(imageJobKind, r.processImage)
+	queue.Handle(warningJobKind, r.sendWarning)
 }
@@ -0,0 +1,24 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/c-wiren/snackstoppen-backend/apperr"
+	"github.com/c-wiren/snackstoppen-backend/auth"
+	"github.com/c-wiren/snackstoppen-backend/dataloader"
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+)
+
+// loadReview returns a visible review as seen by the current user, batched
+// with the other reviews of the request
+func loadReview(ctx context.Context, id int) (*model.Review, error) {
+	var viewer *int
+	if user := auth.ForContext(ctx); user != nil {
+		viewer = &user.ID
+	}
+	review, err := dataloader.For(ctx).Review(id, viewer)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load review failed")
+	}
+	return review, nil
+}
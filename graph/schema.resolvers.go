@@ -4,261 +4,738 @@ package graph
 // will be copied through when generating and any unknown code will be moved to the end.
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
 	"fmt"
 	"image"
-	"image/png"
+	"log"
 	"math/big"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/c-wiren/snackstoppen-backend/apperr"
 	"github.com/c-wiren/snackstoppen-backend/auth"
+	"github.com/c-wiren/snackstoppen-backend/dataloader"
 	"github.com/c-wiren/snackstoppen-backend/graph/generated"
 	"github.com/c-wiren/snackstoppen-backend/graph/model"
-	"github.com/disintegration/imaging"
+	"github.com/c-wiren/snackstoppen-backend/mail"
+	"github.com/c-wiren/snackstoppen-backend/notifications"
+	"github.com/c-wiren/snackstoppen-backend/pubsub"
+	"github.com/c-wiren/snackstoppen-backend/stats"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 	jwt "github.com/golang-jwt/jwt/v4"
-	minio "github.com/minio/minio-go/v7"
-	"github.com/vektah/gqlparser/v2/gqlerror"
+	pgx "github.com/jackc/pgx/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
+func (r *chipResolver) Brand(ctx context.Context, obj *model.Chip) (*model.Brand, error) {
+	brand, err := dataloader.For(ctx).Brand(obj.BrandID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load brand failed")
+	}
+	if brand == nil {
+		return nil, apperr.New(apperr.UnknownBrand, "Brand does not exist")
+	}
+	return brand, nil
+}
+
+func (r *chipResolver) Images(ctx context.Context, obj *model.Chip) (*model.ImageSet, error) {
+	if obj.Image == nil {
+		return nil, nil
+	}
+	return r.imageSet("snacks/" + *obj.Image), nil
+}
+
+func (r *chipResolver) ImageStatus(ctx context.Context, obj *model.Chip) (*model.ImageStatus, error) {
+	if obj.ImageStatus == nil {
+		return nil, nil
+	}
+	status := model.ImageStatus(strings.ToUpper(*obj.ImageStatus))
+	return &status, nil
+}
+
+func (r *chipResolver) FlavorProfile(ctx context.Context, obj *model.Chip) (*model.FlavorProfile, error) {
+	return flavorProfile(obj), nil
+}
+
+func (r *chipResolver) Barcodes(ctx context.Context, obj *model.Chip) ([]string, error) {
+	barcodes, err := dataloader.For(ctx).Barcodes(obj.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load barcodes failed")
+	}
+	if barcodes == nil {
+		barcodes = []string{}
+	}
+	return barcodes, nil
+}
+
+func (r *commentResolver) Review(ctx context.Context, obj *model.Comment) (*model.Review, error) {
+	return loadReview(ctx, obj.ReviewID)
+}
+
+func (r *commentResolver) User(ctx context.Context, obj *model.Comment) (*model.User, error) {
+	if obj.Deleted {
+		return nil, nil
+	}
+	user, err := dataloader.For(ctx).User(obj.UserID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load user failed")
+	}
+	return user, nil
+}
+
+func (r *commentResolver) Replies(ctx context.Context, obj *model.Comment, first *int, after *string) (*model.CommentConnection, error) {
+	size, err := pageSize(first, 20)
+	if err != nil {
+		return nil, err
+	}
+	var args queryArgs
+	q := `SELECT ` + model.CommentColumns + `
+	FROM comments
+	WHERE comments.parent_id=` + args.add(obj.ID)
+	return r.commentConnection(ctx, q, args, size, after)
+}
+
+func (r *moderationLogEntryResolver) Actor(ctx context.Context, obj *model.ModerationLogEntry) (*model.User, error) {
+	if obj.ActorID == nil {
+		return nil, nil
+	}
+	return dataloader.For(ctx).User(*obj.ActorID)
+}
+
+func (r *moderationLogEntryResolver) Action(ctx context.Context, obj *model.ModerationLogEntry) (model.ModerationAction, error) {
+	return model.ModerationAction(strings.ToUpper(obj.Action)), nil
+}
+
+func (r *moderationLogEntryResolver) Type(ctx context.Context, obj *model.ModerationLogEntry) (model.ContentType, error) {
+	return model.ContentType(strings.ToUpper(obj.Type)), nil
+}
+
+func (r *moderationLogEntryResolver) User(ctx context.Context, obj *model.ModerationLogEntry) (*model.User, error) {
+	if obj.UserID == nil {
+		return nil, nil
+	}
+	return dataloader.For(ctx).User(*obj.UserID)
+}
+
 func (r *mutationResolver) CreateReview(ctx context.Context, review model.NewReview, overwrite *bool) (*model.Review, error) {
 	user := auth.ForContext(ctx)
 	if user == nil {
-		return nil, &gqlerror.Error{Message: "Must be logged in", Extensions: map[string]interface{}{"code": "UNAUTHORIZED"}}
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
 	}
 
 	err := review.Validate()
 	if err != nil {
-		return nil, &gqlerror.Error{Message: err.Error(), Extensions: map[string]interface{}{"code": "USER_INPUT_ERROR"}}
+		return nil, apperr.New(apperr.UserInput, err.Error())
 	}
 
 	if review.Rating < 1 || review.Rating > 10 {
-		return nil, &gqlerror.Error{Message: "Input error", Extensions: map[string]interface{}{"code": "USER_INPUT_ERROR"}}
+		return nil, apperr.New(apperr.UserInput, "Input error")
 	}
 
-	// Delete first if overwrite = true
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	// Delete first if overwrite = true, a hidden review is kept and makes the
+	// new review a duplicate
 	if overwrite != nil && *overwrite {
 		// Remove review from database
-		r.DB.Exec(ctx, `DELETE FROM reviews
-	WHERE chips_id=$1 AND user_id=$2;`, review.Chips, user.ID)
+		var oldReview model.Review
+		err = tx.QueryRow(ctx, `DELETE FROM reviews
+		WHERE chips_id=$1 AND user_id=$2 AND hidden IS NULL
+		RETURNING `+model.ReviewColumns, review.Chips, user.ID).Scan(oldReview.Fields()...)
+		if err != nil && err != pgx.ErrNoRows {
+			return nil, apperr.InternalError(err, "delete review failed")
+		}
+		if err == nil {
+			err = stats.RemoveReview(ctx, tx, review.Chips, reviewScores(&oldReview))
+			if err != nil {
+				return nil, apperr.InternalError(err, "update chip stats failed")
+			}
+		}
 	}
 
 	// Insert review into DB
-	rows, err := r.DB.Query(ctx, `INSERT INTO reviews (chips_id, rating, review, user_id)
-	VALUES ($1, $2, $3, $4)
-	RETURNING id, review, rating, created, likes`, review.Chips, review.Rating, review.Review, user.ID)
+	var flavor model.Flavor
+	if review.Flavor != nil {
+		flavor = model.Flavor(*review.Flavor)
+	}
+	var newReview model.Review
+	err = tx.QueryRow(ctx, `INSERT INTO reviews (chips_id, rating, review, user_id, crunch, saltiness, flavor_intensity, greasiness, value)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING `+model.ReviewColumns, review.Chips, review.Rating, review.Review, user.ID,
+		flavor.Crunch, flavor.Saltiness, flavor.FlavorIntensity, flavor.Greasiness, flavor.Value).Scan(newReview.Fields()...)
 	if err != nil {
-		fmt.Println(err)
-		panic(fmt.Errorf("insert review failed"))
+		return nil, apperr.FromDB(err, "insert review failed")
 	}
-	if !rows.Next() {
-		return nil, gqlerror.Errorf("Insert failed")
+	err = stats.AddReview(ctx, tx, newReview.ChipsID, reviewScores(&newReview))
+	if err != nil {
+		return nil, apperr.InternalError(err, "update chip stats failed")
 	}
-	defer rows.Close()
-	var newReview model.Review
-	newReview.User = new(model.User)
-	err = rows.Scan(&newReview.ID, &newReview.Review, &newReview.Rating, &newReview.Created, &newReview.Likes)
+	err = notifications.AddReview(ctx, tx, user.ID, newReview.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "notify followers failed")
+	}
+	err = pubsub.Publish(ctx, tx, pubsub.ReviewsTopic(user.ID), newReview.ID)
 	if err != nil {
-		fmt.Println(err)
-		panic(fmt.Errorf("db scan review failed"))
+		return nil, apperr.InternalError(err, "publish review failed")
 	}
 
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit review failed")
+	}
 	return &newReview, nil
 }
 
 func (r *mutationResolver) CreateChip(ctx context.Context, chip model.NewChip) (*bool, error) {
-	user := auth.ForContext(ctx)
-	if user == nil || user.Role != "admin" {
-		return nil, &gqlerror.Error{Message: "Must be admin", Extensions: map[string]interface{}{"code": "FORBIDDEN"}}
-	}
-
 	err := chip.Validate()
 	if err != nil {
-		return nil, &gqlerror.Error{Message: err.Error(), Extensions: map[string]interface{}{"code": "USER_INPUT_ERROR"}}
+		return nil, apperr.New(apperr.UserInput, err.Error())
 	}
 
 	var imageURL *string
 	var originalImage image.Image
 	if chip.Image != nil {
 		var err error
-		originalImage, err = png.Decode(chip.Image.File)
+		originalImage, err = decodeImage(chip.Image.File)
 		if err != nil {
-			return nil, &gqlerror.Error{Message: "Invalid image", Extensions: map[string]interface{}{"code": "INVALID_IMAGE"}}
+			return nil, apperr.New(apperr.InvalidImage, "Invalid image")
 		}
-		url := chip.Brand + "-" + chip.Slug + ".png"
+		url := imageName(chip.Brand+"-"+chip.Slug, originalImage)
 		imageURL = &url
 	}
+
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
 	// Insert chip into DB
-	commandTag, err := r.DB.Exec(ctx, `INSERT INTO chips (name,category,subcategory,slug,image,ingredients,brand_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		chip.Name, chip.Category, chip.Subcategory, chip.Slug, imageURL, chip.Ingredients, chip.Brand)
-	if commandTag.RowsAffected() != 1 || err != nil {
-		return nil, gqlerror.Errorf("Could not create chip")
+	var id int
+	err = tx.QueryRow(ctx, `INSERT INTO chips (name,category,subcategory,slug,image,image_status,ingredients,brand_id)
+		VALUES ($1, $2, $3, $4, $5::text, CASE WHEN $5::text IS NULL THEN NULL ELSE 'pending' END, $6, $7)
+		RETURNING id`,
+		chip.Name, chip.Category, chip.Subcategory, chip.Slug, imageURL, chip.Ingredients, chip.Brand).Scan(&id)
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not create chip")
+	}
+	err = setBarcodes(ctx, tx, id, chip.Barcodes)
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not add barcodes")
 	}
 
+	// Upload the original and queue resizing, the chip is only created if both succeed
 	if chip.Image != nil {
-		buff := bytes.NewBuffer(nil)
-		png.Encode(buff, originalImage)
-		_, err = r.S3.PutObject(ctx, "snackstoppen", "original/snacks/"+*imageURL, buff, int64(buff.Len()), minio.PutObjectOptions{ContentType: "image/png"})
+		err = r.uploadImage(ctx, tx, "snacks/"+*imageURL, originalImage, &id)
+		if err != nil {
+			r.removeImage(ctx, "snacks/"+*imageURL)
+			return nil, apperr.InternalError(err, "create chip s3 upload error")
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		if imageURL != nil {
+			r.removeImage(ctx, "snacks/"+*imageURL)
+		}
+		return nil, apperr.InternalError(err, "commit chip failed")
+	}
+
+	err = stats.RefreshBrands(ctx, r.DB, chip.Brand)
+	if err != nil {
+		return nil, apperr.InternalError(err, "refresh brands failed")
+	}
+	r.Suggestions.Invalidate()
+	return nil, nil
+}
+
+func (r *mutationResolver) UpdateChip(ctx context.Context, id int, chip model.EditChip) (*model.Chip, error) {
+	err := chip.Validate()
+	if err != nil {
+		return nil, apperr.New(apperr.UserInput, err.Error())
+	}
+
+	// Get current chip from DB
+	var oldBrand, oldSlug string
+	var oldImage *string
+	err = r.DB.QueryRow(ctx, `SELECT brand_id, slug, image FROM chips WHERE id=$1`, id).Scan(&oldBrand, &oldSlug, &oldImage)
+	if err == pgx.ErrNoRows {
+		return nil, apperr.New(apperr.UnknownChip, "Chip does not exist")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "chip query failed")
+	}
+	newBrand, newSlug := oldBrand, oldSlug
+	if chip.Brand != nil {
+		newBrand = *chip.Brand
+	}
+	if chip.Slug != nil {
+		newSlug = *chip.Slug
+	}
+
+	var imageURL *string
+	var originalImage image.Image
+	if chip.Image != nil {
+		originalImage, err = decodeImage(chip.Image.File)
+		if err != nil {
+			return nil, apperr.New(apperr.InvalidImage, "Invalid image")
+		}
+		url := imageName(newBrand+"-"+newSlug, originalImage)
+		imageURL = &url
+	}
+
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	// Update chip in DB, an empty string clears an optional field
+	commandTag, err := tx.Exec(ctx, `UPDATE chips
+	SET name = COALESCE($1, name),
+	category = COALESCE($2, category),
+	subcategory = NULLIF(COALESCE($3, subcategory), ''),
+	slug = COALESCE($4, slug),
+	image = COALESCE($5::text, image),
+	image_status = CASE WHEN $5::text IS NULL THEN image_status ELSE 'pending' END,
+	ingredients = NULLIF(COALESCE($6, ingredients), ''),
+	brand_id = COALESCE($7, brand_id)
+	WHERE id=$8`,
+		chip.Name, chip.Category, chip.Subcategory, chip.Slug, imageURL, chip.Ingredients, chip.Brand, id)
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not update chip")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return nil, apperr.New(apperr.UnknownChip, "Chip does not exist")
+	}
+	if chip.Barcodes != nil {
+		err = setBarcodes(ctx, tx, id, chip.Barcodes)
 		if err != nil {
-			fmt.Println(err)
+			return nil, apperr.FromDB(err, "Could not update barcodes")
+		}
+	}
+
+	// Upload the original and queue resizing before the new image is committed
+	if imageURL != nil {
+		err = r.uploadImage(ctx, tx, "snacks/"+*imageURL, originalImage, &id)
+		if err == nil {
+			err = tx.Commit(ctx)
 		}
-		resizedImage := imaging.Fit(originalImage, 60, 60, imaging.Box)
-		png.Encode(buff, resizedImage)
-		_, err = r.S3.PutObject(ctx, "snackstoppen", "sm/snacks/"+*imageURL, buff, int64(buff.Len()), minio.PutObjectOptions{ContentType: "image/png"})
 		if err != nil {
-			fmt.Println(err)
+			if oldImage == nil || *oldImage != *imageURL {
+				r.removeImage(ctx, "snacks/"+*imageURL)
+			}
+			return nil, apperr.InternalError(err, "update chip s3 upload error")
 		}
-		resizedImage = imaging.Fit(originalImage, 224, 224, imaging.Box)
-		png.Encode(buff, resizedImage)
-		_, err = r.S3.PutObject(ctx, "snackstoppen", "md/snacks/"+*imageURL, buff, int64(buff.Len()), minio.PutObjectOptions{ContentType: "image/png"})
+	} else {
+		err = tx.Commit(ctx)
 		if err != nil {
-			fmt.Println(err)
+			return nil, apperr.InternalError(err, "commit chip update failed")
 		}
-		resizedImage = imaging.Fit(originalImage, 640, 640, imaging.Box)
-		png.Encode(buff, resizedImage)
-		_, err = r.S3.PutObject(ctx, "snackstoppen", "lg/snacks/"+*imageURL, buff, int64(buff.Len()), minio.PutObjectOptions{ContentType: "image/png"})
+	}
+
+	// Remove the replaced image
+	if imageURL != nil && oldImage != nil && *oldImage != *imageURL {
+		err = r.removeImage(ctx, "snacks/"+*oldImage)
 		if err != nil {
-			fmt.Println(err)
-			// Remove chip from db
-			_, err := r.DB.Exec(ctx, `DELETE FROM chips
-			WHERE slug=$1 AND brand_id=$2`,
-				chip.Slug, chip.Brand)
-			fmt.Println(err)
-			panic(fmt.Errorf("create chip s3 upload error"))
+			log.Println(err)
 		}
 	}
+
+	err = stats.RefreshBrands(ctx, r.DB, oldBrand, newBrand)
+	if err != nil {
+		return nil, apperr.InternalError(err, "refresh brands failed")
+	}
+	r.Suggestions.Invalidate()
+
+	return r.Query().Chip(ctx, newBrand, newSlug)
+}
+
+func (r *mutationResolver) DeleteChip(ctx context.Context, id int) (*bool, error) {
+	// Remove chip from DB
+	var brand string
+	var image *string
+	err := r.DB.QueryRow(ctx, `DELETE FROM chips
+	WHERE id=$1
+	RETURNING brand_id, image`, id).Scan(&brand, &image)
+	if err == pgx.ErrNoRows {
+		return nil, apperr.New(apperr.UnknownChip, "Chip does not exist")
+	}
+	if err != nil {
+		return nil, apperr.FromDB(err, "Chip could not be deleted")
+	}
+
+	if image != nil {
+		err = r.removeImage(ctx, "snacks/"+*image)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	err = stats.RefreshBrands(ctx, r.DB, brand)
+	if err != nil {
+		return nil, apperr.InternalError(err, "refresh brands failed")
+	}
+	r.Suggestions.Invalidate()
 	return nil, nil
 }
 
-func (r *mutationResolver) CreateUser(ctx context.Context, user model.NewUser) (*model.LoginResponse, error) {
-	err := user.Validate()
+func (r *mutationResolver) CreateBrand(ctx context.Context, brand model.NewBrand) (*model.Brand, error) {
+	err := brand.Validate()
 	if err != nil {
-		return nil, &gqlerror.Error{Message: err.Error(), Extensions: map[string]interface{}{"code": "USER_INPUT_ERROR"}}
+		return nil, apperr.New(apperr.UserInput, err.Error())
 	}
 
-	// Parse JWT
-	emailToken, err := jwt.Parse(user.Token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	var imageURL *string
+	var originalImage image.Image
+	if brand.Image != nil {
+		originalImage, err = decodeImage(brand.Image.File)
+		if err != nil {
+			return nil, apperr.New(apperr.InvalidImage, "Invalid image")
 		}
-		return []byte(auth.Secret), nil
-	})
-	if err != nil || !emailToken.Valid {
-		return nil, &gqlerror.Error{Message: "The code is expired", Extensions: map[string]interface{}{"code": "EXPIRED_EMAIL_VERIFICATION"}}
+		url := imageName(brand.ID, originalImage)
+		imageURL = &url
 	}
-	claims, ok := emailToken.Claims.(jwt.MapClaims)
-	if !ok {
-		panic(fmt.Errorf("token claims error"))
+
+	// Insert brand into DB
+	newBrand := &model.Brand{}
+	err = r.DB.QueryRow(ctx, `INSERT INTO brands (id, name, image, count)
+	VALUES ($1, $2, $3, 0)
+	RETURNING id, image, name, count, categories`, brand.ID, brand.Name, imageURL).Scan(
+		&newBrand.ID, &newBrand.Image, &newBrand.Name, &newBrand.Count, &newBrand.Categories)
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not create brand")
 	}
-	email, _ := claims["email"].(string)
-	hash, _ := claims["code"].(string)
 
-	// Check if confirmed email is the same
-	if email != user.Email {
-		return nil, gqlerror.Errorf("Incorrect email address")
+	if brand.Image != nil {
+		err = r.uploadImage(ctx, r.DB, "brands/"+*imageURL, originalImage, nil)
+		if err != nil {
+			// Remove brand from db
+			_, deleteErr := r.DB.Exec(ctx, `DELETE FROM brands WHERE id=$1`, brand.ID)
+			if deleteErr != nil {
+				log.Println(deleteErr)
+			}
+			return nil, apperr.InternalError(err, "create brand s3 upload error")
+		}
+	}
+	r.Suggestions.Invalidate()
+	return newBrand, nil
+}
+
+func (r *mutationResolver) UpdateBrand(ctx context.Context, id string, brand model.EditBrand) (*model.Brand, error) {
+	err := brand.Validate()
+	if err != nil {
+		return nil, apperr.New(apperr.UserInput, err.Error())
+	}
+
+	// The image keeps its name unless the format changes, so uploading
+	// replaces every size variant
+	var imageURL *string
+	if brand.Image != nil {
+		originalImage, err := decodeImage(brand.Image.File)
+		if err != nil {
+			return nil, apperr.New(apperr.InvalidImage, "Invalid image")
+		}
+		url := imageName(id, originalImage)
+		imageURL = &url
+		err = r.uploadImage(ctx, r.DB, "brands/"+url, originalImage, nil)
+		if err != nil {
+			return nil, apperr.InternalError(err, "update brand s3 upload error")
+		}
+	}
+
+	// Update brand in DB
+	var oldImage *string
+	updatedBrand := &model.Brand{}
+	err = r.DB.QueryRow(ctx, `UPDATE brands
+	SET name = COALESCE($1, brands.name),
+	image = COALESCE($2, brands.image)
+	FROM brands AS old
+	WHERE brands.id=$3 AND old.id=brands.id
+	RETURNING old.image, brands.id, brands.image, brands.name, brands.count, brands.categories`, brand.Name, imageURL, id).Scan(
+		&oldImage, &updatedBrand.ID, &updatedBrand.Image, &updatedBrand.Name, &updatedBrand.Count, &updatedBrand.Categories)
+	if err == pgx.ErrNoRows {
+		if imageURL != nil {
+			r.removeImage(ctx, "brands/"+*imageURL)
+		}
+		return nil, apperr.New(apperr.UnknownBrand, "Brand does not exist")
+	}
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not update brand")
+	}
+
+	// Remove the replaced image if it had another format
+	if imageURL != nil && oldImage != nil && *oldImage != *imageURL {
+		err = r.removeImage(ctx, "brands/"+*oldImage)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	r.Suggestions.Invalidate()
+	return updatedBrand, nil
+}
+
+func (r *mutationResolver) CreateUser(ctx context.Context, user model.NewUser, device *string) (*model.LoginResponse, error) {
+	err := user.Validate()
+	if err != nil {
+		return nil, apperr.New(apperr.UserInput, err.Error())
 	}
 
 	// Check if entered code is correct
-	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(user.Code))
+	verification, err := auth.CheckCode(ctx, r.DB, auth.EmailVerification, user.Token, user.Code)
+	if err == auth.ErrCodeExpired {
+		return nil, apperr.New(apperr.ExpiredEmailVerification, "The code is expired")
+	}
+	if err == auth.ErrCodeInvalid {
+		return nil, apperr.New(apperr.InvalidEmailVerification, "Incorrect code")
+	}
 	if err != nil {
-		return nil, &gqlerror.Error{Message: "Incorrect code", Extensions: map[string]interface{}{"code": "INVALID_EMAIL_VERIFICATION"}}
+		return nil, apperr.InternalError(err, "email verification query failed")
+	}
+
+	// Check if confirmed email is the same
+	if verification.Email != user.Email {
+		return nil, apperr.New(apperr.UserInput, "Incorrect email address")
 	}
 
 	// Create password hash
 	passwordHash, _ := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
 
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	// The code can only be used once
+	err = auth.UseCode(ctx, tx, user.Token)
+	if err == auth.ErrCodeExpired {
+		return nil, apperr.New(apperr.ExpiredEmailVerification, "The code is expired")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "use email verification failed")
+	}
+
 	// Insert user into DB
-	rows, err := r.DB.Query(ctx, `INSERT INTO users (username, email, password, firstname, lastname)
+	completeUser := model.CompleteUser{}
+	err = tx.QueryRow(ctx, `INSERT INTO users (username, email, password, firstname, lastname)
 	VALUES ($1, $2, $3, $4, $5)
-	RETURNING username, id, email, firstname, lastname, role, image, created, logout`, user.Username, user.Email, string(passwordHash), user.Firstname, user.Lastname)
-	if !rows.Next() || err != nil {
-		return nil, gqlerror.Errorf("Could not create user")
+	RETURNING username, id, email, firstname, lastname, role, image, created, logout`, user.Username, user.Email, string(passwordHash), user.Firstname, user.Lastname).Scan(&completeUser.Username, &completeUser.ID, &completeUser.Email, &completeUser.Firstname, &completeUser.Lastname, &completeUser.Role, &completeUser.Image, &completeUser.Created, &completeUser.Logout)
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not create user")
 	}
-	defer rows.Close()
-	completeUser := model.CompleteUser{}
-	err = rows.Scan(&completeUser.Username, &completeUser.ID, &completeUser.Email, &completeUser.Firstname, &completeUser.Lastname, &completeUser.Role, &completeUser.Image, &completeUser.Created, &completeUser.Logout)
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not create user")
+	}
+	r.Suggestions.Invalidate()
+
+	// Create a session for this device
+	session, err := auth.CreateSession(ctx, r.DB, completeUser.ID, device)
 	if err != nil {
-		panic(fmt.Errorf("db row scan error"))
+		return nil, apperr.InternalError(err, "create session failed")
 	}
 
 	return auth.CreateLoginResponse(
 		completeUser,
-		true), nil
+		session), nil
 }
 
 func (r *mutationResolver) ValidateEmail(ctx context.Context, email string) (string, error) {
 	err := validation.Validate(&email, is.EmailFormat)
 	if err != nil {
-		return "", &gqlerror.Error{Message: err.Error(), Extensions: map[string]interface{}{"code": "USER_INPUT_ERROR"}}
+		return "", apperr.New(apperr.UserInput, err.Error())
 	}
 	// Check if email exists
 	rows, err := r.DB.Query(ctx, "SELECT 1 FROM users WHERE email=$1", email)
 	if err != nil {
-		panic(fmt.Errorf("db query error"))
+		return "", apperr.InternalError(err, "db query error")
 	}
 	defer rows.Close()
 	if rows.Next() {
-		return "", &gqlerror.Error{Message: "Email already exists", Extensions: map[string]interface{}{"code": "EXISTING_EMAIL"}}
+		return "", apperr.New(apperr.ExistingEmail, "Email already exists")
 	}
 	// Generate random code
 	nBig, _ := rand.Int(rand.Reader, big.NewInt(10000))
 	code := fmt.Sprintf("%04d", nBig)
 
-	// Send email with code
-	message := r.Mailgun.NewMessage("Snackstoppen <noreply@snackstoppen.se>", "Verifieringskod från Snackstoppen", "", email)
-	message.SetHtml(fmt.Sprintf("<p><b>%s</b> är din verifieringskod för Snackstoppen.</p><p>Hälsningar,<br>Snackstoppen</p>", code))
-	_, _, err = r.Mailgun.Send(ctx, message)
+	id, err := auth.CreateCode(ctx, r.DB, auth.EmailVerification, email, nil, code)
+	if err == auth.ErrCodeLimited {
+		return "", apperr.New(apperr.TooManyRequests, "Too many verification codes, try again later")
+	}
 	if err != nil {
-		fmt.Println(err)
-		panic(fmt.Errorf("could not send mailgun email"))
+		return "", apperr.InternalError(err, "create email verification failed")
 	}
 
-	// Create hash from code
-	hash, _ := bcrypt.GenerateFromPassword([]byte(code), 10)
-
-	// Create JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email": email,
-		"code":  string(hash),
-		"exp":   time.Now().Add(time.Minute * 10).Unix(),
-		"iat":   time.Now().Unix(),
+	// Send email with code, once it is stored so that the code works
+	err = r.Mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Verifieringskod från Snackstoppen",
+		HTML:    fmt.Sprintf("<p><b>%s</b> är din verifieringskod för Snackstoppen.</p><p>Hälsningar,<br>Snackstoppen</p>", code),
 	})
-	tokenString, _ := token.SignedString([]byte(auth.Secret))
-	return tokenString, nil
+	if err != nil {
+		return "", apperr.InternalError(err, "could not send email")
+	}
+	return id, nil
+}
+
+func (r *mutationResolver) RequestPasswordReset(ctx context.Context, email string) (string, error) {
+	err := validation.Validate(&email, is.EmailFormat)
+	if err != nil {
+		return "", apperr.New(apperr.UserInput, err.Error())
+	}
+	// Generate random code
+	nBig, _ := rand.Int(rand.Reader, big.NewInt(1000000))
+	code := fmt.Sprintf("%06d", nBig)
+
+	// Get user from DB
+	var userID *int
+	err = r.DB.QueryRow(ctx, "SELECT id FROM users WHERE email=$1", email).Scan(&userID)
+	if err != nil && err != pgx.ErrNoRows {
+		return "", apperr.InternalError(err, "db query error")
+	}
+	// A reset is created even if the email is unknown, so that the
+	// response does not reveal which addresses have an account
+	id, err := auth.CreateCode(ctx, r.DB, auth.PasswordReset, email, userID, code)
+	if err == auth.ErrCodeLimited {
+		return "", apperr.New(apperr.TooManyRequests, "Too many password resets, try again later")
+	}
+	if err != nil {
+		return "", apperr.InternalError(err, "create password reset failed")
+	}
+	if userID != nil {
+		// Send email with code, once the reset is stored so that the code works
+		err = r.Mailer.Send(ctx, mail.Message{
+			To:      email,
+			Subject: "Återställ ditt lösenord på Snackstoppen",
+			HTML:    fmt.Sprintf("<p><b>%s</b> är din kod för att återställa ditt lösenord på Snackstoppen.</p><p>Om du inte har bett om att återställa lösenordet kan du bortse från det här mejlet.</p><p>Hälsningar,<br>Snackstoppen</p>", code),
+		})
+		if err != nil {
+			return "", apperr.InternalError(err, "could not send email")
+		}
+	}
+	return id, nil
+}
+
+func (r *mutationResolver) ResetPassword(ctx context.Context, token string, code string, newPassword string, device *string) (*model.LoginResponse, error) {
+	err := validation.Validate(&newPassword, model.PasswordRules...)
+	if err != nil {
+		return nil, apperr.New(apperr.UserInput, err.Error())
+	}
+
+	// Check if entered code is correct
+	reset, err := auth.CheckCode(ctx, r.DB, auth.PasswordReset, token, code)
+	if err == auth.ErrCodeExpired {
+		return nil, apperr.New(apperr.ExpiredPasswordReset, "The code is expired")
+	}
+	if err == auth.ErrCodeInvalid || (err == nil && reset.UserID == nil) {
+		return nil, apperr.New(apperr.InvalidPasswordReset, "Incorrect code")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "password reset query failed")
+	}
+
+	// Create password hash
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte(newPassword), 10)
+
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	// The reset can only be used once
+	err = auth.UseCode(ctx, tx, token)
+	if err == auth.ErrCodeExpired {
+		return nil, apperr.New(apperr.ExpiredPasswordReset, "The code is expired")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "use password reset failed")
+	}
+
+	// Update password
+	completeUser := model.CompleteUser{}
+	var banned bool
+	err = tx.QueryRow(ctx, `UPDATE users
+	SET password = $1, logout = NOW()
+	WHERE id=$2
+	RETURNING username, id, email, firstname, lastname, role, image, created, logout, COALESCE(banned_until > NOW(), false)`, string(passwordHash), *reset.UserID).Scan(&completeUser.Username, &completeUser.ID, &completeUser.Email, &completeUser.Firstname, &completeUser.Lastname, &completeUser.Role, &completeUser.Image, &completeUser.Created, &completeUser.Logout, &banned)
+	if err == pgx.ErrNoRows {
+		return nil, apperr.New(apperr.ExpiredPasswordReset, "The code is expired")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "db query error")
+	}
+	// Banned users can not log in, so the reset is rolled back
+	if banned {
+		return nil, apperr.New(apperr.Banned, "User is banned")
+	}
+
+	// Log out all devices
+	_, err = tx.Exec(ctx, `DELETE FROM sessions WHERE user_id=$1`, completeUser.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "db sessions not removed")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit password reset failed")
+	}
+
+	// Create a session for this device
+	session, err := auth.CreateSession(ctx, r.DB, completeUser.ID, device)
+	if err != nil {
+		return nil, apperr.InternalError(err, "create session failed")
+	}
+
+	return auth.CreateLoginResponse(
+		completeUser,
+		session), nil
 }
 
-func (r *mutationResolver) Login(ctx context.Context, email string, password string) (*model.LoginResponse, error) {
+func (r *mutationResolver) Login(ctx context.Context, email string, password string, device *string) (*model.LoginResponse, error) {
 	// Get user from DB
-	rows, err := r.DB.Query(ctx, `SELECT username, password, id, email, firstname, lastname, role, image, created, logout FROM users WHERE email=$1 OR username=$2`, email, email)
+	rows, err := r.DB.Query(ctx, `SELECT username, password, id, email, firstname, lastname, role, image, created, logout, COALESCE(banned_until > NOW(), false) FROM users WHERE email=$1 OR username=$2`, email, email)
 	if err != nil {
-		panic(fmt.Errorf("db query error"))
+		return nil, apperr.InternalError(err, "db query error")
 	}
 	defer rows.Close()
 	if !rows.Next() {
-		return nil, &gqlerror.Error{Message: "Incorrect credentials", Extensions: map[string]interface{}{"code": "USER_INPUT_ERROR"}}
+		return nil, apperr.New(apperr.UserInput, "Incorrect credentials")
 	}
 	completeUser := model.CompleteUser{}
-	err = rows.Scan(&completeUser.Username, &completeUser.Password, &completeUser.ID, &completeUser.Email, &completeUser.Firstname, &completeUser.Lastname, &completeUser.Role, &completeUser.Image, &completeUser.Created, &completeUser.Logout)
+	var banned bool
+	err = rows.Scan(&completeUser.Username, &completeUser.Password, &completeUser.ID, &completeUser.Email, &completeUser.Firstname, &completeUser.Lastname, &completeUser.Role, &completeUser.Image, &completeUser.Created, &completeUser.Logout, &banned)
 	if err != nil {
-		fmt.Println(err)
-		panic(fmt.Errorf("db row scan error"))
+		return nil, apperr.InternalError(err, "db row scan error")
 	}
 
 	// Check if password is correct
 	err = bcrypt.CompareHashAndPassword([]byte(completeUser.Password), []byte(password))
 	if err != nil {
-		return nil, &gqlerror.Error{Message: "Incorrect credentials", Extensions: map[string]interface{}{"code": "USER_INPUT_ERROR"}}
+		return nil, apperr.New(apperr.UserInput, "Incorrect credentials")
+	}
+	if banned {
+		return nil, apperr.New(apperr.Banned, "User is banned")
+	}
+
+	// Create a session for this device
+	session, err := auth.CreateSession(ctx, r.DB, completeUser.ID, device)
+	if err != nil {
+		return nil, apperr.InternalError(err, "create session failed")
 	}
 
 	return auth.CreateLoginResponse(
 		completeUser,
-		true), nil
+		session), nil
 }
 
 func (r *mutationResolver) Refresh(ctx context.Context, token string) (*model.LoginResponse, error) {
@@ -270,56 +747,104 @@ func (r *mutationResolver) Refresh(ctx context.Context, token string) (*model.Lo
 		return []byte(auth.Secret), nil
 	})
 	if err != nil || !refreshToken.Valid {
-		return nil, &gqlerror.Error{Message: "The session has expired", Extensions: map[string]interface{}{"code": "AUTHENTICATION_ERROR"}}
+		return nil, apperr.New(apperr.Authentication, "The session has expired")
 	}
 	claims, ok := refreshToken.Claims.(jwt.MapClaims)
 	if !ok {
-		panic(fmt.Errorf("token claims error"))
+		return nil, apperr.InternalError(nil, "token claims error")
 	}
 
 	// Get token data
 	rawId, _ := claims["id"].(float64)
 	id := int(rawId)
-	rawLogout, _ := claims["logout"].(string)
-	logout, _ := time.Parse(time.RFC3339, rawLogout)
+	rawSession, _ := claims["session"].(float64)
+	sessionToken, _ := claims["token"].(string)
+	var session *auth.Session
+	if legacyLogout, ok := claims["logout"].(string); ok && claims["typ"] == nil {
+		// Refresh tokens from before sessions get a session instead
+		session, err = auth.UpgradeLegacySession(ctx, r.DB, id, legacyLogout)
+	} else if claims["typ"] != "refresh" || rawSession == 0 {
+		return nil, apperr.New(apperr.Authentication, "The session has expired")
+	} else {
+		// Replace the refresh token, a token can only be used once
+		session, err = auth.RotateSession(ctx, r.DB, int(rawSession), id, sessionToken)
+	}
+	if err == auth.ErrSessionReused {
+		return nil, apperr.New(apperr.Authentication, "The session has been used on another device")
+	}
+	if err == auth.ErrSessionNotFound {
+		return nil, apperr.New(apperr.Authentication, "The session has been logged out")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "rotate session failed")
+	}
 
 	// Get user from DB
-	rows, err := r.DB.Query(ctx, `SELECT username, password, id, email, firstname, lastname, role, image, created, logout FROM users WHERE id=$1`, id)
+	rows, err := r.DB.Query(ctx, `SELECT username, password, id, email, firstname, lastname, role, image, created, logout, COALESCE(banned_until > NOW(), false) FROM users WHERE id=$1`, id)
 	if err != nil {
-		panic(fmt.Errorf("db query error"))
+		return nil, apperr.InternalError(err, "db query error")
 	}
 	defer rows.Close()
 	if !rows.Next() {
-		return nil, &gqlerror.Error{Message: "User does not exist", Extensions: map[string]interface{}{"code": "AUTHENTICATION_ERROR"}}
+		return nil, apperr.New(apperr.Authentication, "User does not exist")
 	}
 	completeUser := model.CompleteUser{}
-	err = rows.Scan(&completeUser.Username, &completeUser.Password, &completeUser.ID, &completeUser.Email, &completeUser.Firstname, &completeUser.Lastname, &completeUser.Role, &completeUser.Image, &completeUser.Created, &completeUser.Logout)
+	var banned bool
+	err = rows.Scan(&completeUser.Username, &completeUser.Password, &completeUser.ID, &completeUser.Email, &completeUser.Firstname, &completeUser.Lastname, &completeUser.Role, &completeUser.Image, &completeUser.Created, &completeUser.Logout, &banned)
 	if err != nil {
-		fmt.Println(err)
-		panic(fmt.Errorf("db row scan error"))
+		return nil, apperr.InternalError(err, "db row scan error")
 	}
-
-	// Check if all devices has been logged out
-	if logout.Unix() != completeUser.Logout.Unix() {
-		return nil, &gqlerror.Error{Message: "All devices has been logged out", Extensions: map[string]interface{}{"code": "AUTHENTICATION_ERROR"}}
+	if banned {
+		return nil, apperr.New(apperr.Banned, "User is banned")
 	}
 
 	return auth.CreateLoginResponse(
 		completeUser,
-		false), nil
+		session), nil
 }
 
 func (r *mutationResolver) LogoutAll(ctx context.Context) (*bool, error) {
 	user := auth.ForContext(ctx)
 	if user == nil {
-		return nil, &gqlerror.Error{Message: "Must be logged in", Extensions: map[string]interface{}{"code": "UNAUTHORIZED"}}
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
 	}
-	// Update logout date in DB
-	commandTag, err := r.DB.Exec(ctx, `UPDATE users
-	SET logout = NOW()
-	WHERE id=$1`, user.ID)
-	if commandTag.RowsAffected() != 1 || err != nil {
-		panic(fmt.Errorf("db not updated with logout"))
+	defer tx.Rollback(ctx)
+
+	// Remove all sessions from DB
+	_, err = tx.Exec(ctx, `DELETE FROM sessions
+	WHERE user_id=$1`, user.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "db sessions not removed")
+	}
+	// Refresh tokens from before sessions are checked against logout
+	_, err = tx.Exec(ctx, `UPDATE users SET logout = NOW() WHERE id=$1`, user.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "db not updated with logout")
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit logout failed")
+	}
+	return nil, nil
+}
+
+func (r *mutationResolver) RevokeSession(ctx context.Context, id int) (*bool, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	// Remove session from database
+	commandTag, err := r.DB.Exec(ctx, `DELETE FROM sessions
+	WHERE id=$1 AND user_id=$2;`, id, user.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "Session could not be revoked")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return nil, apperr.New(apperr.NotFound, "Session does not exist")
 	}
 	return nil, nil
 }
@@ -327,13 +852,35 @@ func (r *mutationResolver) LogoutAll(ctx context.Context) (*bool, error) {
 func (r *mutationResolver) Like(ctx context.Context, review int) (*model.Review, error) {
 	user := auth.ForContext(ctx)
 	if user == nil {
-		return nil, &gqlerror.Error{Message: "Must be logged in", Extensions: map[string]interface{}{"code": "UNAUTHORIZED"}}
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
 	}
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
 	// Insert like into database
-	commandTag, err := r.DB.Exec(ctx, `INSERT INTO likes(review_id, user_id)
+	commandTag, err := tx.Exec(ctx, `INSERT INTO likes(review_id, user_id)
 	values($1, $2);`, review, user.ID)
-	if commandTag.RowsAffected() != 1 || err != nil {
-		return nil, gqlerror.Errorf("Could not create like")
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not create like")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return nil, apperr.InternalError(nil, "Could not create like")
+	}
+	err = notifications.AddLike(ctx, tx, user.ID, review)
+	if err != nil {
+		return nil, apperr.InternalError(err, "notify like failed")
+	}
+	err = pubsub.Publish(ctx, tx, pubsub.LikesTopic(review), review)
+	if err != nil {
+		return nil, apperr.InternalError(err, "publish like failed")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit like failed")
 	}
 	result := true
 	return &model.Review{ID: review, Liked: &result}, nil
@@ -342,13 +889,31 @@ func (r *mutationResolver) Like(ctx context.Context, review int) (*model.Review,
 func (r *mutationResolver) Unlike(ctx context.Context, review int) (*model.Review, error) {
 	user := auth.ForContext(ctx)
 	if user == nil {
-		return nil, &gqlerror.Error{Message: "Must be logged in", Extensions: map[string]interface{}{"code": "UNAUTHORIZED"}}
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
 	}
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
 	// Remove like from database
-	commandTag, err := r.DB.Exec(ctx, `DELETE FROM likes
+	commandTag, err := tx.Exec(ctx, `DELETE FROM likes
 	WHERE review_id=$1 AND user_id=$2;`, review, user.ID)
-	if commandTag.RowsAffected() != 1 || err != nil {
-		return nil, gqlerror.Errorf("Like could not be removed")
+	if err != nil {
+		return nil, apperr.InternalError(err, "Like could not be removed")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return nil, apperr.New(apperr.NotFound, "The review has not been liked")
+	}
+	err = notifications.RemoveLike(ctx, tx, user.ID, review)
+	if err != nil {
+		return nil, apperr.InternalError(err, "remove like notification failed")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit unlike failed")
 	}
 	result := false
 	return &model.Review{ID: review, Liked: &result}, nil
@@ -357,45 +922,450 @@ func (r *mutationResolver) Unlike(ctx context.Context, review int) (*model.Revie
 func (r *mutationResolver) Follow(ctx context.Context, user int) (*model.User, error) {
 	reqUser := auth.ForContext(ctx)
 	if reqUser == nil {
-		return nil, &gqlerror.Error{Message: "Must be logged in", Extensions: map[string]interface{}{"code": "UNAUTHORIZED"}}
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
 	}
-	// Insert like into database
-	commandTag, err := r.DB.Exec(ctx, `INSERT INTO follows(user_id, follows_user_id)
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	// Insert follow into database
+	commandTag, err := tx.Exec(ctx, `INSERT INTO follows(user_id, follows_user_id)
 	values($1, $2);`, reqUser.ID, user)
-	if commandTag.RowsAffected() != 1 || err != nil {
-		return nil, gqlerror.Errorf("Could not follow user")
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not follow user")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return nil, apperr.InternalError(nil, "Could not follow user")
+	}
+	err = notifications.AddFollow(ctx, tx, reqUser.ID, user)
+	if err != nil {
+		return nil, apperr.InternalError(err, "notify follow failed")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit follow failed")
 	}
 	result := true
 	return &model.User{ID: user, Follow: &result}, nil
 }
 
-func (r *mutationResolver) Unfollow(ctx context.Context, user int) (*model.User, error) {
-	reqUser := auth.ForContext(ctx)
-	if reqUser == nil {
-		return nil, &gqlerror.Error{Message: "Must be logged in", Extensions: map[string]interface{}{"code": "UNAUTHORIZED"}}
-	}
-	// Remove like from database
-	commandTag, err := r.DB.Exec(ctx, `DELETE FROM follows
-	WHERE follows_user_id=$1 AND user_id=$2;`, user, reqUser.ID)
-	if commandTag.RowsAffected() != 1 || err != nil {
-		return nil, gqlerror.Errorf("Could not unfollow user")
-	}
-	result := false
-	return &model.User{ID: user, Follow: &result}, nil
+func (r *mutationResolver) Unfollow(ctx context.Context, user int) (*model.User, error) {
+	reqUser := auth.ForContext(ctx)
+	if reqUser == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	// Remove like from database
+	commandTag, err := tx.Exec(ctx, `DELETE FROM follows
+	WHERE follows_user_id=$1 AND user_id=$2;`, user, reqUser.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "Could not unfollow user")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return nil, apperr.New(apperr.NotFound, "The user is not followed")
+	}
+	err = notifications.RemoveFollow(ctx, tx, reqUser.ID, user)
+	if err != nil {
+		return nil, apperr.InternalError(err, "remove follow notification failed")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit unfollow failed")
+	}
+	result := false
+	return &model.User{ID: user, Follow: &result}, nil
+}
+
+func (r *mutationResolver) UpdateReview(ctx context.Context, id int, rating int, review *string, flavor *model.FlavorInput) (*model.Review, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+
+	err := model.NewReview{Rating: rating, Review: review, Flavor: flavor}.Validate()
+	if err != nil {
+		return nil, apperr.New(apperr.UserInput, err.Error())
+	}
+
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	var oldReview model.Review
+	err = tx.QueryRow(ctx, `SELECT `+model.ReviewColumns+` FROM reviews
+	WHERE id=$1 AND user_id=$2 AND hidden IS NULL
+	FOR UPDATE`, id, user.ID).Scan(oldReview.Fields()...)
+	if err == pgx.ErrNoRows {
+		return nil, apperr.New(apperr.UnknownReview, "Review does not exist")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "review query failed")
+	}
+
+	// Save the current version as a revision
+	_, err = tx.Exec(ctx, `INSERT INTO review_revisions (review_id, rating, review, created, crunch, saltiness, flavor_intensity, greasiness, value)
+	SELECT id, rating, review, COALESCE(edited, created), crunch, saltiness, flavor_intensity, greasiness, value FROM reviews
+	WHERE id=$1`, id)
+	if err != nil {
+		return nil, apperr.InternalError(err, "insert review revision failed")
+	}
+
+	// Update review in place so that id, likes and created are kept
+	newFlavor := oldReview.Flavor
+	if flavor != nil {
+		newFlavor = model.Flavor(*flavor)
+	}
+	var updatedReview model.Review
+	err = tx.QueryRow(ctx, `UPDATE reviews
+	SET rating=$1, review=$2, edited=NOW(), crunch=$3, saltiness=$4, flavor_intensity=$5, greasiness=$6, value=$7
+	WHERE id=$8
+	RETURNING `+model.ReviewColumns, rating, review,
+		newFlavor.Crunch, newFlavor.Saltiness, newFlavor.FlavorIntensity, newFlavor.Greasiness, newFlavor.Value, id).Scan(updatedReview.Fields()...)
+	if err != nil {
+		return nil, apperr.InternalError(err, "update review failed")
+	}
+	err = stats.ChangeScores(ctx, tx, updatedReview.ChipsID, reviewScores(&oldReview), reviewScores(&updatedReview))
+	if err != nil {
+		return nil, apperr.InternalError(err, "update chip stats failed")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit review update failed")
+	}
+	return &updatedReview, nil
+}
+
+func (r *mutationResolver) DeleteReview(ctx context.Context, review int) (*bool, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	// Remove review from database
+	var deletedReview model.Review
+	err = tx.QueryRow(ctx, `DELETE FROM reviews
+	WHERE id=$1 AND user_id=$2
+	RETURNING `+model.ReviewColumns, review, user.ID).Scan(deletedReview.Fields()...)
+	if err == pgx.ErrNoRows {
+		return nil, apperr.New(apperr.UnknownReview, "Review does not exist")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "Review could not be deleted")
+	}
+	if !deletedReview.Hidden {
+		err = stats.RemoveReview(ctx, tx, deletedReview.ChipsID, reviewScores(&deletedReview))
+		if err != nil {
+			return nil, apperr.InternalError(err, "update chip stats failed")
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit review delete failed")
+	}
+	return nil, nil
+}
+
+func (r *mutationResolver) UpdateProfile(ctx context.Context, input model.ProfileInput) (*model.User, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+
+	err := input.Validate()
+	if err != nil {
+		return nil, apperr.New(apperr.UserInput, err.Error())
+	}
+
+	// Upload the new avatar before it is referenced from the DB
+	var imageURL *string
+	if input.Image != nil {
+		originalImage, err := decodeImage(input.Image.File)
+		if err != nil {
+			return nil, apperr.New(apperr.InvalidImage, "Invalid image")
+		}
+		// A new name for every upload so that cached avatars are not reused
+		url := imageName(fmt.Sprintf("%d-%d", user.ID, time.Now().Unix()), originalImage)
+		imageURL = &url
+		err = r.uploadImage(ctx, r.DB, "users/"+url, originalImage, nil)
+		if err != nil {
+			r.removeImage(ctx, "users/"+url)
+			return nil, apperr.InternalError(err, "update profile s3 upload error")
+		}
+	}
+
+	// Update user in DB, an empty string clears a name
+	var oldImage *string
+	updatedUser := &model.User{}
+	err = r.DB.QueryRow(ctx, `UPDATE users
+	SET firstname = NULLIF(COALESCE($1, users.firstname), ''),
+	lastname = NULLIF(COALESCE($2, users.lastname), ''),
+	image = COALESCE($3, users.image)
+	FROM users AS old
+	WHERE users.id=$4 AND old.id=users.id
+	RETURNING old.image, users.id, users.username, users.firstname, users.lastname, users.image, users.created`,
+		input.Firstname, input.Lastname, imageURL, user.ID).Scan(
+		&oldImage, &updatedUser.ID, &updatedUser.Username, &updatedUser.Firstname, &updatedUser.Lastname, &updatedUser.Image, &updatedUser.Created)
+	if err != nil {
+		if imageURL != nil {
+			r.removeImage(ctx, "users/"+*imageURL)
+		}
+		return nil, apperr.FromDB(err, "Could not update profile")
+	}
+
+	// Remove the previous avatar
+	if imageURL != nil && oldImage != nil && *oldImage != *imageURL {
+		err = r.removeImage(ctx, "users/"+*oldImage)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	r.Suggestions.Invalidate()
+	return updatedUser, nil
+}
+
+func (r *mutationResolver) AddComment(ctx context.Context, review int, body string, parent *int) (*model.Comment, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+
+	err := model.CommentBody{Body: body}.Validate()
+	if err != nil {
+		return nil, apperr.New(apperr.UserInput, err.Error())
+	}
+
+	// A reply must be to a comment on the same review that is not deleted or
+	// hidden
+	comment := &model.Comment{}
+	err = r.DB.QueryRow(ctx, `INSERT INTO comments (review_id, user_id, parent_id, body)
+	SELECT $1::integer, $2::integer, $3::integer, $4::text
+	WHERE $3::integer IS NULL OR EXISTS (
+		SELECT 1 FROM comments WHERE id=$3 AND review_id=$1 AND deleted IS NULL AND hidden IS NULL
+	)
+	RETURNING `+model.CommentColumns, review, user.ID, parent, body).Scan(comment.Fields()...)
+	if err == pgx.ErrNoRows {
+		return nil, apperr.New(apperr.UnknownComment, "Comment does not exist")
+	}
+	if err != nil {
+		return nil, apperr.FromDB(err, "Could not create comment")
+	}
+	return comment, nil
+}
+
+func (r *mutationResolver) EditComment(ctx context.Context, id int, body string) (*model.Comment, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+
+	err := model.CommentBody{Body: body}.Validate()
+	if err != nil {
+		return nil, apperr.New(apperr.UserInput, err.Error())
+	}
+
+	comment := &model.Comment{}
+	err = r.DB.QueryRow(ctx, `UPDATE comments
+	SET body=$1, edited=NOW()
+	WHERE id=$2 AND user_id=$3 AND deleted IS NULL AND hidden IS NULL
+	RETURNING `+model.CommentColumns, body, id, user.ID).Scan(comment.Fields()...)
+	if err == pgx.ErrNoRows {
+		return nil, apperr.New(apperr.UnknownComment, "Comment does not exist")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "update comment failed")
+	}
+	return comment, nil
+}
+
+func (r *mutationResolver) DeleteComment(ctx context.Context, id int) (*bool, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+
+	// Only the body is removed so that replies keep their place in the thread.
+	// Moderators may delete any comment.
+	commandTag, err := r.DB.Exec(ctx, `UPDATE comments
+	SET body=NULL, deleted=NOW()
+	WHERE id=$1 AND (user_id=$2 OR $3) AND deleted IS NULL`, id, user.ID, user.Can(model.PermissionModerateContent))
+	if err != nil {
+		return nil, apperr.InternalError(err, "Comment could not be deleted")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return nil, apperr.New(apperr.UnknownComment, "Comment does not exist")
+	}
+	return nil, nil
+}
+
+func (r *mutationResolver) MarkNotificationsRead(ctx context.Context, ids []int) (int, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return 0, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	_, err := r.DB.Exec(ctx, `UPDATE notifications SET read=NOW()
+	WHERE user_id=$1 AND read IS NULL AND ($2::integer[] IS NULL OR id = ANY($2))`, user.ID, ids)
+	if err != nil {
+		return 0, apperr.InternalError(err, "mark notifications read failed")
+	}
+	return r.Query().UnreadNotificationCount(ctx)
+}
+
+func (r *mutationResolver) ReportContent(ctx context.Context, typeArg model.ContentType, id int, reason string) (*bool, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	err := model.ReportReason{Reason: reason}.Validate()
+	if err != nil {
+		return nil, apperr.New(apperr.UserInput, err.Error())
+	}
+	err = r.reportContent(ctx, user.ID, typeArg, id, reason)
+	if err != nil {
+		return nil, err
+	}
+	result := true
+	return &result, nil
+}
+
+func (r *mutationResolver) ResolveReport(ctx context.Context, id int, action model.ModerationAction, note *string, bannedUntil *time.Time) (*model.Report, error) {
+	user := auth.ForContext(ctx)
+	if action == model.ModerationActionBan && !user.Can(model.PermissionBanUsers) {
+		return nil, apperr.New(apperr.Forbidden, "Missing permission "+string(model.PermissionBanUsers))
+	}
+	return r.resolveReport(ctx, &moderation{actorID: user.ID, action: action, note: note, bannedUntil: bannedUntil}, id)
+}
+
+func (r *mutationResolver) HideReview(ctx context.Context, id int, note *string) (*bool, error) {
+	actor := auth.ForContext(ctx)
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	var author int
+	err = tx.QueryRow(ctx, `SELECT user_id FROM reviews WHERE id=$1`, id).Scan(&author)
+	if err == pgx.ErrNoRows {
+		return nil, apperr.New(apperr.UnknownReview, "Review does not exist")
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "review query failed")
+	}
+	err = r.moderate(ctx, tx, &moderation{actorID: actor.ID, action: model.ModerationActionHide,
+		contentType: "review", contentID: id, userID: &author, note: note})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit hide review failed")
+	}
+	return nil, nil
+}
+
+func (r *mutationResolver) BanUser(ctx context.Context, user int, until *time.Time, note *string) (*bool, error) {
+	actor := auth.ForContext(ctx)
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	err = r.moderate(ctx, tx, &moderation{actorID: actor.ID, action: model.ModerationActionBan,
+		contentType: "user", contentID: user, userID: &user, note: note, bannedUntil: until})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit ban failed")
+	}
+	return nil, nil
+}
+
+func (r *mutationResolver) SetRole(ctx context.Context, user int, role model.Role) (*bool, error) {
+	actor := auth.ForContext(ctx)
+	if actor.ID == user {
+		return nil, apperr.New(apperr.Forbidden, "You can not change your own role")
+	}
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "begin transaction failed")
+	}
+	defer tx.Rollback(ctx)
+
+	if role != model.RoleAdmin {
+		err = checkAdminRemains(ctx, tx, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The role is read from the database on every request, so it applies at once
+	commandTag, err := tx.Exec(ctx, `UPDATE users SET role=$1 WHERE id=$2`, strings.ToLower(string(role)), user)
+	if err != nil {
+		return nil, apperr.InternalError(err, "set role failed")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return nil, apperr.New(apperr.UnknownUser, "User does not exist")
+	}
+	// Websocket connections are closed and read it when they reconnect
+	err = pubsub.Publish(ctx, tx, pubsub.UsersTopic(user), user)
+	if err != nil {
+		return nil, apperr.InternalError(err, "publish role failed")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, apperr.InternalError(err, "commit role failed")
+	}
+	return nil, nil
+}
+
+func (r *notificationResolver) Kind(ctx context.Context, obj *model.Notification) (model.NotificationKind, error) {
+	return model.NotificationKind(strings.ToUpper(obj.Kind)), nil
+}
+
+func (r *notificationResolver) Actors(ctx context.Context, obj *model.Notification) ([]*model.User, error) {
+	return notificationActors(ctx, obj)
 }
 
-func (r *mutationResolver) DeleteReview(ctx context.Context, review int) (*bool, error) {
-	user := auth.ForContext(ctx)
-	if user == nil {
-		return nil, &gqlerror.Error{Message: "Must be logged in", Extensions: map[string]interface{}{"code": "UNAUTHORIZED"}}
+func (r *notificationResolver) ActorCount(ctx context.Context, obj *model.Notification) (int, error) {
+	return len(obj.ActorIDs), nil
+}
+
+func (r *notificationResolver) Review(ctx context.Context, obj *model.Notification) (*model.Review, error) {
+	if obj.ReviewID == nil {
+		return nil, nil
 	}
-	// Remove review from database
-	commandTag, err := r.DB.Exec(ctx, `DELETE FROM reviews
-	WHERE id=$1 AND user_id=$2;`, review, user.ID)
-	if commandTag.RowsAffected() != 1 || err != nil {
-		return nil, gqlerror.Errorf("Review could not be deleted")
+	return loadReview(ctx, *obj.ReviewID)
+}
+
+func (r *notificationResolver) Message(ctx context.Context, obj *model.Notification) (string, error) {
+	actors, err := notificationActors(ctx, obj)
+	if err != nil {
+		return "", err
 	}
-	return nil, nil
+	return notificationMessage(obj.Kind, actors, len(obj.ActorIDs)), nil
 }
 
 func (r *queryResolver) Search(ctx context.Context, q string) (*model.SearchResponse, error) {
@@ -408,7 +1378,7 @@ func (r *queryResolver) Search(ctx context.Context, q string) (*model.SearchResp
 	for i, str := range qArray {
 		qArgs[i] = str
 	}
-	query := `SELECT chips.id,chips.name,chips.slug,chips.image,chips.brand_id, brands.name
+	query := `SELECT ` + model.ChipColumns + `
 	FROM chips INNER JOIN brands ON chips.brand_id=brands.id
 	WHERE`
 	for i := range qArray {
@@ -417,152 +1387,144 @@ func (r *queryResolver) Search(ctx context.Context, q string) (*model.SearchResp
 		}
 		query += fmt.Sprintf(` word_similarity($%d, chips.name || ' ' || brands.name) > 0.6`, i+1)
 	}
-	query += `ORDER BY reviews DESC, length(chips.name), brands.name LIMIT 10;`
+	query += `
+	ORDER BY reviews DESC, length(chips.name), brands.name LIMIT 10`
 	var chips []*model.Chip
 	rows, err := r.DB.Query(ctx, query, qArgs...)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("search (chips) query failed"))
+		return nil, apperr.InternalError(err, "search (chips) query failed")
 	}
+	defer rows.Close()
 	for rows.Next() {
-		chip := &model.Chip{}
-		brand := &model.Brand{}
-		chip.Brand = brand
-		err := rows.Scan(&chip.ID, &chip.Name, &chip.Slug, &chip.Image, &brand.ID, &brand.Name)
+		chip, err := model.ScanChip(rows)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("search (chips) scan failed"))
-
+			return nil, apperr.InternalError(err, "search (chips) scan failed")
 		}
 		chips = append(chips, chip)
 	}
+	if rows.Err() != nil {
+		return nil, apperr.InternalError(rows.Err(), "search (chips) query failed")
+	}
 
 	var user *model.User
 	rows, err = r.DB.Query(ctx, `SELECT id, username, firstname,lastname, image FROM users WHERE username=$1`, q)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("search (user) query failed"))
-
+		return nil, apperr.InternalError(err, "search (user) query failed")
 	}
 	defer rows.Close()
 	if rows.Next() {
 		user = &model.User{}
 		err := rows.Scan(&user.ID, &user.Username, &user.Firstname, &user.Lastname, &user.Image)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("search (user) scan failed"))
-
+			return nil, apperr.InternalError(err, "search (user) scan failed")
 		}
 	}
 	return &model.SearchResponse{Chips: chips, User: user}, nil
 }
 
-func (r *queryResolver) Chip(ctx context.Context, brand string, slug string) (*model.Chip, error) {
-	rows, err := r.DB.Query(ctx, `SELECT chips.name,category,subcategory,chips.slug,chips.image,ingredients,chips.id,chips.rating,chips.reviews,brands.id,brands.image,brands.count,brands.name
-	FROM chips INNER JOIN brands ON chips.brand_id=brands.id WHERE chips.brand_id=$1 AND chips.slug=$2 LIMIT 1`, brand, slug)
+func (r *queryResolver) SearchConnection(ctx context.Context, q string, types []model.SearchResultType, category *string, brand *string, first *int, after *string) (*model.SearchConnection, error) {
+	size, err := pageSize(first, 20)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("chip query failed"))
+		return nil, err
+	}
+	return r.search(ctx, q, types, category, brand, size, after)
+}
+
+func (r *queryResolver) Suggest(ctx context.Context, prefix string, limit *int) ([]*model.Suggestion, error) {
+	size := 8
+	if limit != nil {
+		if *limit < 1 || *limit > maxSuggestions {
+			return nil, apperr.New(apperr.UserInput, fmt.Sprintf("limit must be between 1 and %d", maxSuggestions))
+		}
+		size = *limit
+	}
+	suggestions := []*model.Suggestion{}
+	for _, entry := range r.Suggestions.Lookup(prefix, size) {
+		suggestions = append(suggestions, &model.Suggestion{
+			Type:   model.SearchResultType(entry.Kind),
+			ID:     entry.ID,
+			Label:  entry.Label,
+			Detail: optional(entry.Detail),
+			Brand:  optional(entry.Brand),
+			Slug:   optional(entry.Slug),
+		})
+	}
+	return suggestions, nil
+}
 
+func (r *queryResolver) Chip(ctx context.Context, brand string, slug string) (*model.Chip, error) {
+	rows, err := r.DB.Query(ctx, `SELECT `+model.ChipColumns+`
+	FROM chips WHERE chips.brand_id=$1 AND chips.slug=$2 LIMIT 1`, brand, slug)
+	if err != nil {
+		return nil, apperr.InternalError(err, "chip query failed")
 	}
 	defer rows.Close()
 	if rows.Next() {
-		chip := &model.Chip{}
-		brand := &model.Brand{}
-		chip.Brand = brand
-		err := rows.Scan(&chip.Name, &chip.Category, &chip.Subcategory, &chip.Slug, &chip.Image, &chip.Ingredients, &chip.ID, &chip.Rating, &chip.Reviews, &brand.ID, &brand.Image, &brand.Count, &brand.Name)
+		chip, err := model.ScanChip(rows)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("chip scan failed"))
-
+			return nil, apperr.InternalError(err, "chip scan failed")
 		}
 		return chip, nil
 	}
 	return nil, nil
 }
 
-func (r *queryResolver) Chips(ctx context.Context, brand *string, category *string, subcategory []*string, orderBy *model.ChipSortByInput, limit *int, offset *int) ([]*model.Chip, error) {
-	argCount := 0
-	var args []interface{}
-	q := `
-	SELECT chips.name,category,subcategory,chips.slug,chips.image,ingredients,chips.id,chips.rating,chips.reviews,brands.id,brands.image,brands.count,brands.name
-	FROM chips INNER JOIN brands ON chips.brand_id=brands.id`
-
-	where := ""
-	if brand != nil {
-		argCount++
-		where += fmt.Sprint(" chips.brand_id=$", argCount)
-		args = append(args, brand)
+func (r *queryResolver) ChipByBarcode(ctx context.Context, code string) (*model.Chip, error) {
+	gtin, ok := model.GTIN(code)
+	if !ok {
+		return nil, apperr.New(apperr.InvalidBarcode, "Invalid barcode")
 	}
-	if category != nil {
-		if where != "" {
-			where += " AND"
-		}
-		argCount++
-		where += fmt.Sprint(" chips.category=$", argCount)
-		args = append(args, category)
+	chip, err := model.ScanChip(r.DB.QueryRow(ctx, `SELECT `+model.ChipColumns+`
+	FROM barcodes INNER JOIN chips ON barcodes.chips_id=chips.id
+	WHERE barcodes.gtin=$1`, gtin))
+	if err == pgx.ErrNoRows {
+		return nil, nil
 	}
-	if len(subcategory) > 0 {
-		if where != "" {
-			where += " AND"
-		}
-		where += " chips.subcategory IN ("
-		for i, subcat := range subcategory {
-			if i > 0 {
-				where += ","
-			}
-			argCount++
-			where += fmt.Sprint("$", argCount)
-			args = append(args, subcat)
-		}
-		where += ")"
+	if err != nil {
+		return nil, apperr.InternalError(err, "chip by barcode query failed")
 	}
+	return chip, nil
+}
 
-	if orderBy != nil && *orderBy == model.ChipSortByInputTop {
-		where += " chips.reviews >= 3"
-	}
+func (r *queryResolver) Chips(ctx context.Context, brand *string, category *string, subcategory []*string, orderBy *model.ChipSortByInput, limit *int, offset *int) ([]*model.Chip, error) {
+	var args queryArgs
+	q := `
+	SELECT ` + model.ChipColumns + `
+	FROM chips`
 
-	if where != "" {
-		q += " WHERE" + where
+	where := chipFilter(&args, brand, category, subcategory, orderBy)
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
 	}
 
 	if orderBy != nil {
-		switch *orderBy {
-		case model.ChipSortByInputNameAsc:
+		if sort, ok := numericChipSort(*orderBy); ok {
+			q += " ORDER BY " + sort.column
+			if sort.desc {
+				q += " DESC NULLS LAST"
+			}
+			q += ", chips.name"
+		} else {
 			q += " ORDER BY chips.name"
-		case model.ChipSortByInputRatingDesc:
-			q += " ORDER BY chips.rating DESC, chips.name"
-		case model.ChipSortByInputTop:
-			q += " ORDER BY chips.rating DESC, chips.name"
 		}
 	}
 
 	if limit != nil {
-		argCount++
-		q += fmt.Sprint(" LIMIT $", argCount)
-		args = append(args, limit)
+		q += " LIMIT " + args.add(limit)
 	}
 	if offset != nil {
-		argCount++
-		q += fmt.Sprint(" OFFSET $", argCount)
-		args = append(args, offset)
+		q += " OFFSET " + args.add(offset)
 	}
 	var chips []*model.Chip
 	rows, err := r.DB.Query(ctx, q, args...)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("chips query failed"))
-
+		return nil, apperr.InternalError(err, "chips query failed")
 	}
+	defer rows.Close()
 	for rows.Next() {
-		chip := &model.Chip{}
-		brand := &model.Brand{}
-		chip.Brand = brand
-		err := rows.Scan(&chip.Name, &chip.Category, &chip.Subcategory, &chip.Slug, &chip.Image, &chip.Ingredients, &chip.ID, &chip.Rating, &chip.Reviews, &brand.ID, &brand.Image, &brand.Count, &brand.Name)
+		chip, err := model.ScanChip(rows)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("chips scan failed"))
-
+			return nil, apperr.InternalError(err, "chips scan failed")
 		}
 		chips = append(chips, chip)
 	}
@@ -570,20 +1532,93 @@ func (r *queryResolver) Chips(ctx context.Context, brand *string, category *stri
 	return chips, nil
 }
 
+func (r *queryResolver) ChipsConnection(ctx context.Context, brand *string, category *string, subcategory []*string, orderBy *model.ChipSortByInput, first *int, after *string) (*model.ChipConnection, error) {
+	size, err := pageSize(first, 20)
+	if err != nil {
+		return nil, err
+	}
+	var args queryArgs
+	where := chipFilter(&args, brand, category, subcategory, orderBy)
+
+	// Keyset pagination, the cursor holds the sort key of the last chip
+	var order string
+	var cursor func(chip *model.Chip) string
+	switch {
+	case orderBy != nil && *orderBy == model.ChipSortByInputNameAsc:
+		order = "chips.name, chips.id"
+		cursor = func(chip *model.Chip) string { return encodeCursor(chip.Name, chip.ID) }
+		if after != nil {
+			var name string
+			var id int
+			if decodeCursor(*after, &name, &id) != nil {
+				return nil, errInvalidCursor
+			}
+			where = append(where, fmt.Sprintf("(chips.name, chips.id) > (%s, %s)", args.add(name), args.add(id)))
+		}
+	case orderBy != nil:
+		sort, _ := numericChipSort(*orderBy)
+		// NULLS LAST matches the index of chips.score, the filter of the sort
+		// has already left out chips without a value
+		direction, comparison := "", ">"
+		if sort.desc {
+			direction, comparison = " DESC NULLS LAST", "<"
+		}
+		order = sort.column + direction + ", chips.name, chips.id"
+		cursor = func(chip *model.Chip) string { return encodeCursor(sort.value(chip), chip.Name, chip.ID) }
+		if after != nil {
+			var sortValue float64
+			var name string
+			var id int
+			if decodeCursor(*after, &sortValue, &name, &id) != nil {
+				return nil, errInvalidCursor
+			}
+			valueArg := args.add(sortValue)
+			where = append(where, fmt.Sprintf("(%s %s %s OR (%s = %s AND (chips.name, chips.id) > (%s, %s)))",
+				sort.column, comparison, valueArg, sort.column, valueArg, args.add(name), args.add(id)))
+		}
+	default:
+		order = "chips.id"
+		cursor = func(chip *model.Chip) string { return encodeCursor(chip.ID) }
+		if after != nil {
+			var id int
+			if decodeCursor(*after, &id) != nil {
+				return nil, errInvalidCursor
+			}
+			where = append(where, "chips.id > "+args.add(id))
+		}
+	}
+
+	q := `SELECT ` + model.ChipColumns + ` FROM chips`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to know if there is a next page
+	q += " ORDER BY " + order + " LIMIT " + args.add(size+1)
+
+	edges, pageInfo, err := queryPage(ctx, r.DB, q, args, size, after, func(rows pgx.Rows) (*model.ChipEdge, error) {
+		chip, err := model.ScanChip(rows)
+		if err != nil {
+			return nil, err
+		}
+		return &model.ChipEdge{Cursor: cursor(chip), Node: chip}, nil
+	}, func(edge *model.ChipEdge) string { return edge.Cursor })
+	if err != nil {
+		return nil, apperr.InternalError(err, "chips connection query failed")
+	}
+	return &model.ChipConnection{Edges: edges, PageInfo: pageInfo}, nil
+}
+
 func (r *queryResolver) Brand(ctx context.Context, id string) (*model.Brand, error) {
 	rows, err := r.DB.Query(ctx, `SELECT id, image, name, count, categories FROM brands WHERE id=$1 LIMIT 1`, id)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("brand query failed"))
-
+		return nil, apperr.InternalError(err, "brand query failed")
 	}
 	defer rows.Close()
 	if rows.Next() {
 		brand := &model.Brand{}
 		err := rows.Scan(&brand.ID, &brand.Image, &brand.Name, &brand.Count, &brand.Categories)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("brand scan failed"))
+			return nil, apperr.InternalError(err, "brand scan failed")
 		}
 		return brand, nil
 	}
@@ -598,17 +1633,14 @@ func (r *queryResolver) Brands(ctx context.Context, orderBy *model.BrandSortByIn
 	}
 	rows, err := r.DB.Query(ctx, q)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("brands query failed"))
-
+		return nil, apperr.InternalError(err, "brands query failed")
 	}
+	defer rows.Close()
 	for rows.Next() {
 		brand := &model.Brand{}
 		err := rows.Scan(&brand.ID, &brand.Image, &brand.Name, &brand.Count)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("brands scan failed"))
-
+			return nil, apperr.InternalError(err, "brands scan failed")
 		}
 		brands = append(brands, brand)
 	}
@@ -623,13 +1655,8 @@ func (r *queryResolver) Review(ctx context.Context, id *int, author *string, chi
 	}
 	argCount := 0
 	var args []interface{}
-	q := `SELECT reviews.id, reviews.rating, reviews.review, reviews.created, reviews.edited, reviews.likes,
-	users.id, users.username, users.firstname, users.lastname, users.image,
-	chips.id, chips.name, chips.slug, chips.image, chips.rating, chips.reviews, chips.category, chips.subcategory,
-	brands.id, brands.name, likes.user_id IS NOT NULL AS liked
+	q := `SELECT ` + model.ReviewColumns + `, ` + likedColumn + `
 	FROM reviews INNER JOIN users ON reviews.user_id=users.id
-	INNER JOIN chips ON reviews.chips_id=chips.id
-	INNER JOIN brands ON chips.brand_id=brands.id
 	`
 	argCount++
 	q += fmt.Sprint(" LEFT JOIN likes ON reviews.id=likes.review_id AND likes.user_id=$", argCount)
@@ -637,11 +1664,11 @@ func (r *queryResolver) Review(ctx context.Context, id *int, author *string, chi
 
 	if id != nil {
 		argCount++
-		q += fmt.Sprint(" WHERE reviews.id=$", argCount)
+		q += fmt.Sprint(" WHERE "+visibleReviews+" AND reviews.id=$", argCount)
 		args = append(args, id)
 	} else {
 		argCount++
-		q += fmt.Sprint(" WHERE users.username=$", argCount)
+		q += fmt.Sprint(" WHERE "+visibleReviews+" AND users.username=$", argCount)
 		args = append(args, author)
 
 		argCount++
@@ -653,24 +1680,14 @@ func (r *queryResolver) Review(ctx context.Context, id *int, author *string, chi
 
 	rows, err := r.DB.Query(ctx, q, args...)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("review query failed"))
-
+		return nil, apperr.InternalError(err, "review query failed")
 	}
 	defer rows.Close()
 	if rows.Next() {
 		review := &model.Review{}
-		user := &model.User{}
-		brand := &model.Brand{}
-		chips := &model.Chip{}
-		review.User = user
-		review.Chips = chips
-		chips.Brand = brand
-
-		err := rows.Scan(&review.ID, &review.Rating, &review.Review, &review.Created, &review.Edited, &review.Likes, &user.ID, &user.Username, &user.Firstname, &user.Lastname, &user.Image, &chips.ID, &chips.Name, &chips.Slug, &chips.Image, &chips.Rating, &chips.Reviews, &chips.Category, &chips.Subcategory, &brand.ID, &brand.Name, &review.Liked)
+		err := rows.Scan(append(review.Fields(), &review.Liked)...)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("review scan failed"))
+			return nil, apperr.InternalError(err, "review scan failed")
 		}
 		return review, nil
 	}
@@ -685,21 +1702,21 @@ func (r *queryResolver) Reviews(ctx context.Context, chips *int, author *string,
 	}
 
 	if chips != nil && author != nil {
-		return nil, gqlerror.Errorf("Select by either chip or user")
+		return nil, apperr.New(apperr.UserInput, "Select by either chip or user")
 	}
 	if chips != nil {
 		argCount := 0
 		var args []interface{}
 		q := `
-		SELECT reviews.id, reviews.rating, reviews.review, reviews.created, reviews.edited, reviews.likes, users.id, users.username, users.firstname, users.lastname, users.image, likes.user_id IS NOT NULL AS liked
-		FROM reviews INNER JOIN users ON reviews.user_id=users.id`
+		SELECT ` + model.ReviewColumns + `, ` + likedColumn + `
+		FROM reviews`
 		// Check if user liked a review
 		argCount++
 		q += fmt.Sprint(" LEFT JOIN likes ON reviews.id=likes.review_id AND likes.user_id=$", argCount)
 		args = append(args, userID)
 
 		argCount++
-		q += fmt.Sprint(" WHERE reviews.chips_id=$", argCount)
+		q += fmt.Sprint(" WHERE "+visibleReviews+" AND reviews.chips_id=$", argCount)
 		args = append(args, chips)
 		if orderBy != nil && *orderBy == model.ReviewSortByInputDateDesc {
 			q += " ORDER BY reviews.created DESC"
@@ -717,19 +1734,14 @@ func (r *queryResolver) Reviews(ctx context.Context, chips *int, author *string,
 		var reviews []*model.Review
 		rows, err := r.DB.Query(ctx, q, args...)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("reviews (chips) query failed"))
-
+			return nil, apperr.InternalError(err, "reviews (chips) query failed")
 		}
+		defer rows.Close()
 		for rows.Next() {
 			review := &model.Review{}
-			user := &model.User{}
-			review.User = user
-			err := rows.Scan(&review.ID, &review.Rating, &review.Review, &review.Created, &review.Edited, &review.Likes, &user.ID, &user.Username, &user.Firstname, &user.Lastname, &user.Image, &review.Liked)
+			err := rows.Scan(append(review.Fields(), &review.Liked)...)
 			if err != nil {
-				fmt.Print(err)
-				panic(fmt.Errorf("reviews (chips) scan failed"))
-
+				return nil, apperr.InternalError(err, "reviews (chips) scan failed")
 			}
 			reviews = append(reviews, review)
 		}
@@ -740,11 +1752,9 @@ func (r *queryResolver) Reviews(ctx context.Context, chips *int, author *string,
 		argCount := 0
 		var args []interface{}
 		q := `
-		SELECT reviews.id, reviews.rating, reviews.review, reviews.created, reviews.edited, reviews.likes, chips.id, chips.name, chips.slug, chips.image, chips.rating, chips.reviews, chips.category, chips.subcategory, brands.id, brands.name, likes.user_id IS NOT NULL AS liked
+		SELECT ` + model.ReviewColumns + `, ` + likedColumn + `
 		FROM users
-		INNER JOIN reviews ON users.id=reviews.user_id
-		INNER JOIN chips ON reviews.chips_id=chips.id
-		INNER JOIN brands ON chips.brand_id=brands.id`
+		INNER JOIN reviews ON users.id=reviews.user_id`
 
 		// Check if user liked a review
 		argCount++
@@ -752,7 +1762,7 @@ func (r *queryResolver) Reviews(ctx context.Context, chips *int, author *string,
 		args = append(args, userID)
 
 		argCount++
-		q += fmt.Sprint(" WHERE users.username=$", argCount)
+		q += fmt.Sprint(" WHERE "+visibleReviews+" AND users.username=$", argCount)
 		args = append(args, author)
 		if orderBy != nil && *orderBy == model.ReviewSortByInputDateDesc {
 			q += " ORDER BY reviews.created DESC"
@@ -770,27 +1780,46 @@ func (r *queryResolver) Reviews(ctx context.Context, chips *int, author *string,
 		var reviews []*model.Review
 		rows, err := r.DB.Query(ctx, q, args...)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("reviews (author) query failed"))
-
+			return nil, apperr.InternalError(err, "reviews (author) query failed")
 		}
+		defer rows.Close()
 		for rows.Next() {
 			review := &model.Review{}
-			chips := &model.Chip{}
-			brand := &model.Brand{}
-			chips.Brand = brand
-			review.Chips = chips
-			err := rows.Scan(&review.ID, &review.Rating, &review.Review, &review.Created, &review.Edited, &review.Likes, &chips.ID, &chips.Name, &chips.Slug, &chips.Image, &chips.Rating, &chips.Reviews, &chips.Category, &chips.Subcategory, &brand.ID, &brand.Name, &review.Liked)
+			err := rows.Scan(append(review.Fields(), &review.Liked)...)
 			if err != nil {
-				fmt.Print(err)
-				panic(fmt.Errorf("reviews (author) query failed"))
+				return nil, apperr.InternalError(err, "reviews (author) query failed")
 			}
 			reviews = append(reviews, review)
 		}
 
 		return reviews, nil
 	}
-	return nil, gqlerror.Errorf("Select by either chip or author")
+	return nil, apperr.New(apperr.UserInput, "Select by either chip or author")
+}
+
+func (r *queryResolver) ReviewsConnection(ctx context.Context, chips *int, author *string, first *int, after *string) (*model.ReviewConnection, error) {
+	user := auth.ForContext(ctx)
+	var userID *int
+	if user != nil {
+		userID = &user.ID
+	}
+	if (chips != nil) == (author != nil) {
+		return nil, apperr.New(apperr.UserInput, "Select by either chip or author")
+	}
+	size, err := pageSize(first, 10)
+	if err != nil {
+		return nil, err
+	}
+	var args queryArgs
+	q := `SELECT ` + model.ReviewColumns + `, ` + likedColumn + `
+	FROM reviews INNER JOIN users ON reviews.user_id=users.id
+	LEFT JOIN likes ON reviews.id=likes.review_id AND likes.user_id=` + args.add(userID)
+	if chips != nil {
+		q += " WHERE reviews.chips_id=" + args.add(chips)
+	} else {
+		q += " WHERE users.username=" + args.add(author)
+	}
+	return r.reviewConnection(ctx, q, args, size, after)
 }
 
 func (r *queryResolver) User(ctx context.Context, username string) (*model.User, error) {
@@ -806,18 +1835,14 @@ func (r *queryResolver) User(ctx context.Context, username string) (*model.User,
 	LEFT JOIN follows ON users.id=follows.follows_user_id AND follows.user_id=$1
 	WHERE username=$2 LIMIT 1`, userID, username)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("user query failed"))
-
+		return nil, apperr.InternalError(err, "user query failed")
 	}
 	defer rows.Close()
 	if rows.Next() {
 		user := &model.User{}
 		err := rows.Scan(&user.ID, &user.Username, &user.Firstname, &user.Lastname, &user.Image, &user.Created, &user.Following, &user.Followers, &user.Follow)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("user scan failed"))
-
+			return nil, apperr.InternalError(err, "user scan failed")
 		}
 		return user, nil
 	}
@@ -834,7 +1859,7 @@ func (r *queryResolver) Users(ctx context.Context, followers *string, following
 	var users []*model.User
 	var person *string
 	if (following != nil) == (followers != nil) {
-		return nil, &gqlerror.Error{Message: "Must choose either following or followers", Extensions: map[string]interface{}{"code": "USER_INPUT_ERROR"}}
+		return nil, apperr.New(apperr.UserInput, "Must choose either following or followers")
 	}
 	if following != nil {
 		q = `SELECT users.id, users.username, users.firstname, users.lastname, users.image, users.created,
@@ -854,17 +1879,14 @@ func (r *queryResolver) Users(ctx context.Context, followers *string, following
 	}
 	rows, err := r.DB.Query(ctx, q, person, userID)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("users query failed"))
-
+		return nil, apperr.InternalError(err, "users query failed")
 	}
+	defer rows.Close()
 	for rows.Next() {
 		user := &model.User{}
 		err := rows.Scan(&user.ID, &user.Username, &user.Firstname, &user.Lastname, &user.Image, &user.Created, &user.Follow)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("users scan failed"))
-
+			return nil, apperr.InternalError(err, "users scan failed")
 		}
 		users = append(users, user)
 	}
@@ -877,57 +1899,407 @@ func (r *queryResolver) Activity(ctx context.Context, limit int, offset int) ([]
 	if reqUser != nil {
 		userID = reqUser.ID
 	} else {
-		return nil, &gqlerror.Error{Message: "Must be logged in", Extensions: map[string]interface{}{"code": "UNAUTHORIZED"}}
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
 	}
-	q := /* sql */ `SELECT reviews.id, reviews.rating, reviews.review, reviews.created, reviews.edited, reviews.likes,
-	users.id, users.username, users.firstname, users.lastname, users.image,
-	chips.id, chips.name, chips.slug, chips.image, chips.rating, chips.reviews, chips.category, chips.subcategory,
-	brands.id, brands.name,
-	likes.user_id IS NOT NULL AS liked
+	q := /* sql */ `SELECT ` + model.ReviewColumns + `, ` + likedColumn + `
 	FROM /*(SELECT follows_user_id FROM follows where user_id=$1 union select $1)*/follows
-	INNER JOIN users ON follows.follows_user_id=users.id
 	INNER JOIN reviews ON follows.follows_user_id=reviews.user_id
-	INNER JOIN chips ON reviews.chips_id=chips.id
-	INNER JOIN brands ON chips.brand_id=brands.id
 	LEFT JOIN likes ON reviews.id=likes.review_id AND likes.user_id=$1
-	WHERE follows.user_id=$1
+	WHERE follows.user_id=$1 AND ` + visibleReviews + `
 	ORDER BY reviews.created DESC
 	LIMIT $2 OFFSET $3`
 
 	var reviews []*model.Review
 	rows, err := r.DB.Query(ctx, q, userID, limit, offset)
 	if err != nil {
-		fmt.Print(err)
-		panic(fmt.Errorf("activity query failed"))
-
+		return nil, apperr.InternalError(err, "activity query failed")
 	}
+	defer rows.Close()
 	for rows.Next() {
 		review := &model.Review{}
-		user := &model.User{}
-		brand := &model.Brand{}
-		chips := &model.Chip{}
-		review.User = user
-		review.Chips = chips
-		chips.Brand = brand
-
-		err := rows.Scan(
-			&review.ID, &review.Rating, &review.Review, &review.Created, &review.Edited, &review.Likes,
-			&user.ID, &user.Username, &user.Firstname, &user.Lastname, &user.Image, &chips.ID, &chips.Name, &chips.Slug, &chips.Image, &chips.Rating, &chips.Reviews, &chips.Category, &chips.Subcategory,
-			&brand.ID, &brand.Name, &review.Liked)
+		err := rows.Scan(append(review.Fields(), &review.Liked)...)
 		if err != nil {
-			fmt.Print(err)
-			panic(fmt.Errorf("activity scan failed"))
+			return nil, apperr.InternalError(err, "activity scan failed")
 		}
 		reviews = append(reviews, review)
 	}
 	return reviews, nil
 }
 
+func (r *queryResolver) ActivityConnection(ctx context.Context, first *int, after *string) (*model.ReviewConnection, error) {
+	reqUser := auth.ForContext(ctx)
+	if reqUser == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	size, err := pageSize(first, 20)
+	if err != nil {
+		return nil, err
+	}
+	var args queryArgs
+	userArg := args.add(reqUser.ID)
+	q := `SELECT ` + model.ReviewColumns + `, ` + likedColumn + `
+	FROM follows
+	INNER JOIN reviews ON follows.follows_user_id=reviews.user_id
+	LEFT JOIN likes ON reviews.id=likes.review_id AND likes.user_id=` + userArg + `
+	WHERE follows.user_id=` + userArg
+	return r.reviewConnection(ctx, q, args, size, after)
+}
+
+func (r *queryResolver) MySessions(ctx context.Context) ([]*model.Session, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	rows, err := r.DB.Query(ctx, `SELECT id, device, user_agent, ip, created, last_used
+	FROM sessions WHERE user_id=$1
+	ORDER BY last_used DESC`, user.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "sessions query failed")
+	}
+	defer rows.Close()
+	var sessions []*model.Session
+	for rows.Next() {
+		session := &model.Session{}
+		err := rows.Scan(&session.ID, &session.Device, &session.UserAgent, &session.IP, &session.Created, &session.LastUsed)
+		if err != nil {
+			return nil, apperr.InternalError(err, "sessions scan failed")
+		}
+		session.Current = session.ID == user.SessionID
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (r *queryResolver) Notifications(ctx context.Context, first *int, after *string) (*model.NotificationConnection, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	size, err := pageSize(first, 20)
+	if err != nil {
+		return nil, err
+	}
+	var args queryArgs
+	q := `SELECT ` + model.NotificationColumns + `
+	FROM notifications
+	WHERE notifications.user_id=` + args.add(user.ID)
+	if after != nil {
+		var updated time.Time
+		var id int
+		if decodeCursor(*after, &updated, &id) != nil {
+			return nil, errInvalidCursor
+		}
+		q += fmt.Sprintf(" AND (notifications.updated, notifications.id) < (%s, %s)", args.add(updated), args.add(id))
+	}
+	// Fetch one extra row to know if there is a next page
+	q += " ORDER BY notifications.updated DESC, notifications.id DESC LIMIT " + args.add(size+1)
+
+	edges, pageInfo, err := queryPage(ctx, r.DB, q, args, size, after, func(rows pgx.Rows) (*model.NotificationEdge, error) {
+		notification := &model.Notification{}
+		err := rows.Scan(notification.Fields()...)
+		return &model.NotificationEdge{Cursor: encodeCursor(notification.Updated, notification.ID), Node: notification}, err
+	}, func(edge *model.NotificationEdge) string { return edge.Cursor })
+	if err != nil {
+		return nil, apperr.InternalError(err, "notifications query failed")
+	}
+	return &model.NotificationConnection{Edges: edges, PageInfo: pageInfo}, nil
+}
+
+func (r *queryResolver) UnreadNotificationCount(ctx context.Context) (int, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return 0, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	var count int
+	err := r.DB.QueryRow(ctx, `SELECT count(*) FROM notifications WHERE user_id=$1 AND read IS NULL`, user.ID).Scan(&count)
+	if err != nil {
+		return 0, apperr.InternalError(err, "unread notifications query failed")
+	}
+	return count, nil
+}
+
+func (r *queryResolver) ModerationQueue(ctx context.Context, first *int, after *string) (*model.ReportConnection, error) {
+	size, err := pageSize(first, 20)
+	if err != nil {
+		return nil, err
+	}
+	var args queryArgs
+	q := `SELECT ` + model.ReportColumns + `
+	FROM reports
+	WHERE reports.resolved IS NULL`
+	if after != nil {
+		var created time.Time
+		var id int
+		if decodeCursor(*after, &created, &id) != nil {
+			return nil, errInvalidCursor
+		}
+		q += fmt.Sprintf(" AND (reports.created, reports.id) > (%s, %s)", args.add(created), args.add(id))
+	}
+	// Fetch one extra row to know if there is a next page
+	q += " ORDER BY reports.created, reports.id LIMIT " + args.add(size+1)
+
+	edges, pageInfo, err := queryPage(ctx, r.DB, q, args, size, after, func(rows pgx.Rows) (*model.ReportEdge, error) {
+		report := &model.Report{}
+		err := rows.Scan(report.Fields()...)
+		return &model.ReportEdge{Cursor: encodeCursor(report.Created, report.ID), Node: report}, err
+	}, func(edge *model.ReportEdge) string { return edge.Cursor })
+	if err != nil {
+		return nil, apperr.InternalError(err, "moderation queue query failed")
+	}
+	return &model.ReportConnection{Edges: edges, PageInfo: pageInfo}, nil
+}
+
+func (r *queryResolver) ModerationLog(ctx context.Context, first *int, after *string) (*model.ModerationLogConnection, error) {
+	size, err := pageSize(first, 20)
+	if err != nil {
+		return nil, err
+	}
+	var args queryArgs
+	q := `SELECT ` + model.ModerationLogColumns + `
+	FROM moderation_log`
+	if after != nil {
+		var created time.Time
+		var id int
+		if decodeCursor(*after, &created, &id) != nil {
+			return nil, errInvalidCursor
+		}
+		q += fmt.Sprintf(" WHERE (moderation_log.created, moderation_log.id) < (%s, %s)", args.add(created), args.add(id))
+	}
+	// Fetch one extra row to know if there is a next page
+	q += " ORDER BY moderation_log.created DESC, moderation_log.id DESC LIMIT " + args.add(size+1)
+
+	edges, pageInfo, err := queryPage(ctx, r.DB, q, args, size, after, func(rows pgx.Rows) (*model.ModerationLogEdge, error) {
+		entry := &model.ModerationLogEntry{}
+		err := rows.Scan(entry.Fields()...)
+		return &model.ModerationLogEdge{Cursor: encodeCursor(entry.Created, entry.ID), Node: entry}, err
+	}, func(edge *model.ModerationLogEdge) string { return edge.Cursor })
+	if err != nil {
+		return nil, apperr.InternalError(err, "moderation log query failed")
+	}
+	return &model.ModerationLogConnection{Edges: edges, PageInfo: pageInfo}, nil
+}
+
+func (r *reportResolver) Type(ctx context.Context, obj *model.Report) (model.ContentType, error) {
+	return model.ContentType(strings.ToUpper(obj.Type)), nil
+}
+
+func (r *reportResolver) Review(ctx context.Context, obj *model.Report) (*model.Review, error) {
+	if obj.Type != "review" {
+		return nil, nil
+	}
+	return r.reportedReview(ctx, obj.ContentID)
+}
+
+func (r *reportResolver) Comment(ctx context.Context, obj *model.Report) (*model.Comment, error) {
+	if obj.Type != "comment" {
+		return nil, nil
+	}
+	return r.reportedComment(ctx, obj.ContentID)
+}
+
+func (r *reportResolver) User(ctx context.Context, obj *model.Report) (*model.User, error) {
+	if obj.UserID == nil {
+		return nil, nil
+	}
+	return dataloader.For(ctx).User(*obj.UserID)
+}
+
+func (r *reportResolver) Reporter(ctx context.Context, obj *model.Report) (*model.User, error) {
+	if obj.ReporterID == nil {
+		return nil, nil
+	}
+	return dataloader.For(ctx).User(*obj.ReporterID)
+}
+
+func (r *reportResolver) Action(ctx context.Context, obj *model.Report) (*model.ModerationAction, error) {
+	if obj.Action == nil {
+		return nil, nil
+	}
+	action := model.ModerationAction(strings.ToUpper(*obj.Action))
+	return &action, nil
+}
+
+func (r *reviewResolver) Chips(ctx context.Context, obj *model.Review) (*model.Chip, error) {
+	chip, err := dataloader.For(ctx).Chip(obj.ChipsID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load chip failed")
+	}
+	return chip, nil
+}
+
+func (r *reviewResolver) User(ctx context.Context, obj *model.Review) (*model.User, error) {
+	user, err := dataloader.For(ctx).User(obj.UserID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load user failed")
+	}
+	return user, nil
+}
+
+func (r *reviewResolver) Revisions(ctx context.Context, obj *model.Review) ([]*model.ReviewRevision, error) {
+	// Revisions are only visible to the author and moderators
+	user := auth.ForContext(ctx)
+	if user == nil || (user.ID != obj.UserID && !user.Can(model.PermissionModerateContent)) {
+		return nil, nil
+	}
+	revisions, err := dataloader.For(ctx).Revisions(obj.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load review revisions failed")
+	}
+	return revisions, nil
+}
+
+func (r *reviewResolver) Comments(ctx context.Context, obj *model.Review, first *int, after *string) (*model.CommentConnection, error) {
+	size, err := pageSize(first, 20)
+	if err != nil {
+		return nil, err
+	}
+	var args queryArgs
+	q := `SELECT ` + model.CommentColumns + `
+	FROM comments
+	WHERE comments.review_id=` + args.add(obj.ID) + ` AND comments.parent_id IS NULL`
+	return r.commentConnection(ctx, q, args, size, after)
+}
+
+func (r *searchConnectionResolver) Facets(ctx context.Context, obj *model.SearchConnection) (*model.SearchFacets, error) {
+	return r.searchFacets(ctx, obj.Query)
+}
+
+func (r *searchHitResolver) Chip(ctx context.Context, obj *model.SearchHit) (*model.Chip, error) {
+	if obj.Type != model.SearchResultTypeChip {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(obj.Key)
+	if err != nil {
+		return nil, apperr.InternalError(err, "search hit id failed")
+	}
+	chip, err := dataloader.For(ctx).Chip(id)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load chip failed")
+	}
+	return chip, nil
+}
+
+func (r *searchHitResolver) Brand(ctx context.Context, obj *model.SearchHit) (*model.Brand, error) {
+	if obj.Type != model.SearchResultTypeBrand {
+		return nil, nil
+	}
+	brand, err := dataloader.For(ctx).Brand(obj.Key)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load brand failed")
+	}
+	return brand, nil
+}
+
+func (r *searchHitResolver) User(ctx context.Context, obj *model.SearchHit) (*model.User, error) {
+	if obj.Type != model.SearchResultTypeUser {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(obj.Key)
+	if err != nil {
+		return nil, apperr.InternalError(err, "search hit id failed")
+	}
+	user, err := dataloader.For(ctx).User(id)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load user failed")
+	}
+	return user, nil
+}
+
+func (r *subscriptionResolver) ActivityAdded(ctx context.Context) (<-chan *model.Review, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	// Users followed after the subscription started are not included
+	topics, err := r.followedTopics(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	events := r.PubSub.Subscribe(ctx, topics...)
+	return relay(ctx, events, func(event pubsub.Event) (*model.Review, error) {
+		return r.Query().Review(ctx, &event.ID, nil, nil)
+	}), nil
+}
+
+func (r *subscriptionResolver) ReviewLiked(ctx context.Context, review int) (<-chan *model.Review, error) {
+	events := r.PubSub.Subscribe(ctx, pubsub.LikesTopic(review))
+	return relay(ctx, events, func(event pubsub.Event) (*model.Review, error) {
+		return r.Query().Review(ctx, &event.ID, nil, nil)
+	}), nil
+}
+
+func (r *subscriptionResolver) NotificationReceived(ctx context.Context) (<-chan *model.Notification, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, apperr.New(apperr.Unauthorized, "Must be logged in")
+	}
+	events := r.PubSub.Subscribe(ctx, pubsub.NotificationsTopic(user.ID))
+	return relay(ctx, events, func(event pubsub.Event) (*model.Notification, error) {
+		return r.notification(ctx, user.ID, event.ID)
+	}), nil
+}
+
+func (r *userResolver) Followers(ctx context.Context, obj *model.User) (*int, error) {
+	// Users read with their followers, e.g. by user, need no extra query
+	if obj.Followers != nil {
+		return obj.Followers, nil
+	}
+	followers, err := dataloader.For(ctx).Followers(obj.ID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "load followers failed")
+	}
+	return &followers, nil
+}
+
+// Chip returns generated.ChipResolver implementation.
+func (r *Resolver) Chip() generated.ChipResolver { return &chipResolver{r} }
+
+// Comment returns generated.CommentResolver implementation.
+func (r *Resolver) Comment() generated.CommentResolver { return &commentResolver{r} }
+
+// ModerationLogEntry returns generated.ModerationLogEntryResolver implementation.
+func (r *Resolver) ModerationLogEntry() generated.ModerationLogEntryResolver {
+	return &moderationLogEntryResolver{r}
+}
+
 // Mutation returns generated.MutationResolver implementation.
 func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
 
+// Notification returns generated.NotificationResolver implementation.
+func (r *Resolver) Notification() generated.NotificationResolver { return &notificationResolver{r} }
+
 // Query returns generated.QueryResolver implementation.
 func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 
+// Report returns generated.ReportResolver implementation.
+func (r *Resolver) Report() generated.ReportResolver { return &reportResolver{r} }
+
+// Review returns generated.ReviewResolver implementation.
+func (r *Resolver) Review() generated.ReviewResolver { return &reviewResolver{r} }
+
+// SearchConnection returns generated.SearchConnectionResolver implementation.
+func (r *Resolver) SearchConnection() generated.SearchConnectionResolver {
+	return &searchConnectionResolver{r}
+}
+
+// SearchHit returns generated.SearchHitResolver implementation.
+func (r *Resolver) SearchHit() generated.SearchHitResolver { return &searchHitResolver{r} }
+
+// Subscription returns generated.SubscriptionResolver implementation.
+func (r *Resolver) Subscription() generated.SubscriptionResolver { return &subscriptionResolver{r} }
+
+// User returns generated.UserResolver implementation.
+func (r *Resolver) User() generated.UserResolver { return &userResolver{r} }
+
+type chipResolver struct{ *Resolver }
+type commentResolver struct{ *Resolver }
+type moderationLogEntryResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
+type notificationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type reportResolver struct{ *Resolver }
+type reviewResolver struct{ *Resolver }
+type searchConnectionResolver struct{ *Resolver }
+type searchHitResolver struct{ *Resolver }
+type subscriptionResolver struct{ *Resolver }
+type userResolver struct{ *Resolver }
@@ -0,0 +1,240 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/c-wiren/snackstoppen-backend/apperr"
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/jackc/pgx/v4"
+)
+
+// Matched words are marked with control characters by ts_headline, so that
+// the text can be HTML escaped before they are replaced with <mark> tags
+const (
+	headlineOptions = "StartSel=\x01, StopSel=\x02, HighlightAll=true"
+	snippetOptions  = "StartSel=\x01, StopSel=\x02, MaxFragments=1, MaxWords=15, MinWords=5"
+)
+
+// maxSuggestions limits the suggestions returned by suggest
+const maxSuggestions = 20
+
+var highlightReplacer = strings.NewReplacer("\x01", "<mark>", "\x02", "</mark>")
+
+// highlightHTML escapes a ts_headline result and marks the matched words
+func highlightHTML(headline string) string {
+	return highlightReplacer.Replace(html.EscapeString(headline))
+}
+
+// minQueryLength is the shortest search that is run, shorter ones match
+// too much to be useful
+const minQueryLength = 3
+
+// searchable reports whether q is long enough and has a word to search for
+func searchable(q string) bool {
+	return utf8.RuneCountInString(q) >= minQueryLength && prefixQuery(q) != ""
+}
+
+// prefixWords splits a search into words matched by prefix, e.g. "Estrella
+// dil" becomes "estrella:*" and "dil:*". Anything but letters and digits
+// separates words, so every word is a valid tsquery.
+func prefixWords(q string) []string {
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return words
+}
+
+// prefixQuery turns a search into a tsquery matching every word by prefix,
+// e.g. "Estrella dil" becomes "estrella:* & dil:*"
+func prefixQuery(q string) string {
+	return strings.Join(prefixWords(q), " & ")
+}
+
+// searchHits is a CTE with the keys and ranks of the chips, brands and users
+// matching a search. Words are matched with the Swedish dictionary, except
+// user names, and misspellings with word_similarity. The threshold is the
+// pg_trgm.word_similarity_threshold setting, 0.6 by default.
+//
+// Chips match together with their brand, e.g. "estrella dill", which no index
+// covers. Candidates matching any word or the misspelled search by either the
+// chip or the brand are collected with indexes first.
+func searchHits(args *queryArgs, q string) string {
+	tsquery := args.add(prefixQuery(q))
+	anyWord := args.add(strings.Join(prefixWords(q), " | "))
+	text := args.add(q)
+	return fmt.Sprintf(`WITH query AS (
+		SELECT to_tsquery('swedish', %[1]s) AS swedish, to_tsquery('simple', %[1]s) AS simple,
+		to_tsquery('swedish', %[2]s) AS swedish_any
+	),
+	chip_candidates AS (
+		SELECT chips.id FROM query, chips
+		WHERE chips.search @@ query.swedish_any OR %[3]s <%% chips.name
+		UNION
+		SELECT chips.id FROM query, brands INNER JOIN chips ON chips.brand_id=brands.id
+		WHERE brands.search @@ query.swedish_any OR %[3]s <%% brands.name
+	),
+	hits AS (
+		SELECT 'CHIP' AS type, chips.id::text AS key,
+		(ts_rank(chips.search || brands.search, query.swedish) + word_similarity(%[3]s, chips.name || ' ' || brands.name))::double precision AS rank,
+		chips.category, chips.brand_id
+		FROM query, chip_candidates
+		INNER JOIN chips ON chip_candidates.id=chips.id
+		INNER JOIN brands ON chips.brand_id=brands.id
+		WHERE (chips.search || brands.search) @@ query.swedish OR %[3]s <%% (chips.name || ' ' || brands.name)
+		UNION ALL
+		SELECT 'BRAND', brands.id,
+		(ts_rank(brands.search, query.swedish) + word_similarity(%[3]s, brands.name))::double precision,
+		NULL, NULL
+		FROM query, brands
+		WHERE brands.search @@ query.swedish OR %[3]s <%% brands.name
+		UNION ALL
+		SELECT 'USER', users.id::text,
+		(ts_rank(users.search, query.simple) + word_similarity(%[3]s, users.username))::double precision,
+		NULL, NULL
+		FROM query, users
+		WHERE users.search @@ query.simple OR %[3]s <%% users.username
+	)`, tsquery, anyWord, text)
+}
+
+// search pages through the hits of a search, best match first
+func (r *queryResolver) search(ctx context.Context, q string, types []model.SearchResultType, category *string, brand *string, first int, after *string) (*model.SearchConnection, error) {
+	q = strings.TrimSpace(q)
+	connection := &model.SearchConnection{Edges: []*model.SearchEdge{}, Query: q}
+	if !searchable(q) {
+		connection.PageInfo = newPageInfo(nil, nil, false, after)
+		return connection, nil
+	}
+
+	var args queryArgs
+	page := `SELECT type, key, rank FROM hits`
+	var where []string
+	if len(types) > 0 {
+		typeNames := make([]string, len(types))
+		for i, t := range types {
+			typeNames[i] = string(t)
+		}
+		where = append(where, "type = ANY("+args.add(typeNames)+")")
+	}
+	if category != nil {
+		where = append(where, "category="+args.add(category))
+	}
+	if brand != nil {
+		where = append(where, "brand_id="+args.add(brand))
+	}
+	if after != nil {
+		var rank float64
+		var hitType, key string
+		if decodeCursor(*after, &rank, &hitType, &key) != nil {
+			return nil, errInvalidCursor
+		}
+		rankArg := args.add(rank)
+		where = append(where, fmt.Sprintf("(rank < %s OR (rank = %s AND (type, key) > (%s, %s)))",
+			rankArg, rankArg, args.add(hitType), args.add(key)))
+	}
+	if len(where) > 0 {
+		page += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to know if there is a next page
+	page += " ORDER BY rank DESC, type, key LIMIT " + args.add(first+1)
+
+	// Headlines are only made for the hits on the page
+	headline := args.add(headlineOptions)
+	snippet := args.add(snippetOptions)
+	query := searchHits(&args, q) + fmt.Sprintf(`
+	SELECT page.type, page.key, page.rank,
+	CASE page.type
+		WHEN 'CHIP' THEN ts_headline('swedish', chips.name, query.swedish, %[1]s)
+		WHEN 'BRAND' THEN ts_headline('swedish', brands.name, query.swedish, %[1]s)
+		ELSE ts_headline('simple', users.username, query.simple, %[1]s)
+	END,
+	CASE WHEN to_tsvector('swedish', COALESCE(chips.ingredients, '')) @@ query.swedish
+	THEN ts_headline('swedish', chips.ingredients, query.swedish, %[2]s) END
+	FROM query, (%[3]s) AS page
+	LEFT JOIN chips ON chips.id = CASE WHEN page.type='CHIP' THEN page.key::integer END
+	LEFT JOIN brands ON brands.id = CASE WHEN page.type='BRAND' THEN page.key END
+	LEFT JOIN users ON users.id = CASE WHEN page.type='USER' THEN page.key::integer END
+	ORDER BY page.rank DESC, page.type, page.key`, headline, snippet, page)
+
+	edges, pageInfo, err := queryPage(ctx, r.DB, query, args, first, after, func(rows pgx.Rows) (*model.SearchEdge, error) {
+		hit := &model.SearchHit{}
+		var hitType string
+		err := rows.Scan(&hitType, &hit.Key, &hit.Rank, &hit.Highlight, &hit.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		hit.Type = model.SearchResultType(hitType)
+		hit.Highlight = highlightHTML(hit.Highlight)
+		if hit.Snippet != nil {
+			snippet := highlightHTML(*hit.Snippet)
+			hit.Snippet = &snippet
+		}
+		return &model.SearchEdge{Cursor: encodeCursor(hit.Rank, hit.Type, hit.Key), Node: hit}, nil
+	}, func(edge *model.SearchEdge) string { return edge.Cursor })
+	if err != nil {
+		return nil, apperr.InternalError(err, "search query failed")
+	}
+	connection.Edges, connection.PageInfo = edges, pageInfo
+	return connection, nil
+}
+
+// searchFacets counts every hit of a search by type, and the chips among them
+// by category and brand
+func (r *Resolver) searchFacets(ctx context.Context, q string) (*model.SearchFacets, error) {
+	facets := &model.SearchFacets{
+		Types:      []*model.SearchFacet{},
+		Categories: []*model.SearchFacet{},
+		Brands:     []*model.SearchFacet{},
+	}
+	if !searchable(q) {
+		return facets, nil
+	}
+	var args queryArgs
+	rows, err := r.DB.Query(ctx, searchHits(&args, q)+`
+	SELECT 'type', type, type, count(*) FROM hits GROUP BY type
+	UNION ALL
+	SELECT 'category', category, category, count(*) FROM hits WHERE category IS NOT NULL GROUP BY category
+	UNION ALL
+	SELECT 'brand', brands.id, brands.name, count(*) FROM hits INNER JOIN brands ON hits.brand_id=brands.id
+	GROUP BY brands.id
+	ORDER BY 4 DESC, 3`, args...)
+	if err != nil {
+		return nil, apperr.InternalError(err, "search facets query failed")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		facet := &model.SearchFacet{}
+		err := rows.Scan(&kind, &facet.Value, &facet.Label, &facet.Count)
+		if err != nil {
+			return nil, apperr.InternalError(err, "search facets scan failed")
+		}
+		switch kind {
+		case "type":
+			facets.Types = append(facets.Types, facet)
+		case "category":
+			facets.Categories = append(facets.Categories, facet)
+		case "brand":
+			facets.Brands = append(facets.Brands, facet)
+		}
+	}
+	if rows.Err() != nil {
+		return nil, apperr.InternalError(rows.Err(), "search facets query failed")
+	}
+	return facets, nil
+}
+
+// optional returns nil for an empty string
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,68 @@
+package graph
+
+import "testing"
+
+func TestPrefixQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"Estrella dil", "estrella:* & dil:*"},
+		{"  OLW   grill ", "olw:* & grill:*"},
+		{"sour-cream & onion", "sour:* & cream:* & onion:*"},
+		{"Ostbågar", "ostbågar:*"},
+		{"3d", "3d:*"},
+		// tsquery operators are separators, not syntax
+		{"dill:* | !ost", "dill:* & ost:*"},
+		{"'); DROP TABLE chips; --", "drop:* & table:* & chips:*"},
+		{"", ""},
+		{" - & ", ""},
+	}
+	for _, test := range tests {
+		if got := prefixQuery(test.q); got != test.want {
+			t.Errorf("prefixQuery(%q) = %q, want %q", test.q, got, test.want)
+		}
+	}
+}
+
+func TestSearchable(t *testing.T) {
+	tests := []struct {
+		q    string
+		want bool
+	}{
+		{"dil", true},
+		{"Estrella dill", true},
+		{"åäö", true},
+		{"di", false},
+		{"ö", false},
+		{"", false},
+		{"- &", false},
+		{"  a  ", true},
+	}
+	for _, test := range tests {
+		if got := searchable(test.q); got != test.want {
+			t.Errorf("searchable(%q) = %v, want %v", test.q, got, test.want)
+		}
+	}
+}
+
+func TestHighlightHTML(t *testing.T) {
+	tests := []struct {
+		headline string
+		want     string
+	}{
+		{"Estrella \x01Dill\x02chips", "Estrella <mark>Dill</mark>chips"},
+		{"\x01Sourcream\x02 & \x01Onion\x02", "<mark>Sourcream</mark> &amp; <mark>Onion</mark>"},
+		{"<script>alert(1)</script> \x01dill\x02", "&lt;script&gt;alert(1)&lt;/script&gt; <mark>dill</mark>"},
+		{`"Chips" med 'dill'`, "&#34;Chips&#34; med &#39;dill&#39;"},
+		// Tags in the text are escaped, only the markers become tags
+		{"<mark>dill</mark>", "&lt;mark&gt;dill&lt;/mark&gt;"},
+		{"Ostbågar", "Ostbågar"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := highlightHTML(test.headline); got != test.want {
+			t.Errorf("highlightHTML(%q) = %q, want %q", test.headline, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package graph
+
+import (
+	"context"
+	"log"
+
+	"github.com/c-wiren/snackstoppen-backend/apperr"
+	"github.com/c-wiren/snackstoppen-backend/dataloader"
+	"github.com/c-wiren/snackstoppen-backend/graph/model"
+	"github.com/c-wiren/snackstoppen-backend/pubsub"
+	"github.com/jackc/pgx/v4"
+)
+
+// relay loads what every event is about and sends it to a subscription.
+// Events that fail to load or load nothing are skipped, the subscription ends
+// when the events do. The loaders are refreshed for every event, since the
+// connection they belong to can stay open for hours.
+func relay[T any](ctx context.Context, events <-chan pubsub.Event, load func(pubsub.Event) (*T, error)) <-chan *T {
+	values := make(chan *T, 1)
+	go func() {
+		defer close(values)
+		for event := range events {
+			dataloader.Refresh(ctx)
+			value, err := load(event)
+			if err != nil {
+				log.Printf("subscription: %s %d: %v", event.Topic, event.ID, err)
+				continue
+			}
+			if value == nil {
+				continue
+			}
+			select {
+			case values <- value:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return values
+}
+
+// followedTopics returns the review topics of the users that the user follows
+func (r *Resolver) followedTopics(ctx context.Context, userID int) ([]string, error) {
+	rows, err := r.DB.Query(ctx, `SELECT follows_user_id FROM follows WHERE user_id=$1`, userID)
+	if err != nil {
+		return nil, apperr.InternalError(err, "follows query failed")
+	}
+	defer rows.Close()
+	var topics []string
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, apperr.InternalError(err, "follows scan failed")
+		}
+		topics = append(topics, pubsub.ReviewsTopic(id))
+	}
+	if rows.Err() != nil {
+		return nil, apperr.InternalError(rows.Err(), "follows query failed")
+	}
+	return topics, nil
+}
+
+// notification returns a notification of the user
+func (r *Resolver) notification(ctx context.Context, userID int, id int) (*model.Notification, error) {
+	notification := &model.Notification{}
+	err := r.DB.QueryRow(ctx, `SELECT `+model.NotificationColumns+`
+	FROM notifications
+	WHERE notifications.id=$1 AND notifications.user_id=$2`, id, userID).Scan(notification.Fields()...)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, apperr.InternalError(err, "notification query failed")
+	}
+	return notification, nil
+}
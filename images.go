@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/c-wiren/snackstoppen-backend/graph"
+	"github.com/jackc/pgx/v4"
+)
+
+const imagesUsage = `usage: server images regenerate [--brand X] [--size sm|md|lg] [--workers N] [--restart]`
+
+// imagesCommand runs "server images", which maintains the stored images
+func imagesCommand(ctx context.Context, resolver *graph.Resolver, args []string) {
+	if len(args) == 0 || args[0] != "regenerate" {
+		fmt.Fprintln(os.Stderr, imagesUsage)
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("images regenerate", flag.ExitOnError)
+	brand := flags.String("brand", "", "only regenerate images of chips from this brand")
+	size := flags.String("size", "", "only regenerate this size")
+	workers := flags.Int("workers", 4, "number of images processed at the same time")
+	restart := flags.Bool("restart", false, "discard the progress of an earlier run")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, imagesUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+	if (*size != "" && !graph.IsImageSize(*size)) || *workers < 1 || flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Stop on interrupt, finished images are kept so the run can be resumed
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	r := &regeneration{resolver: resolver, size: *size}
+	if *brand != "" {
+		r.brand = brand
+	}
+	err := r.run(ctx, *workers, *restart)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Regeneration failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// regeneration recreates the resized versions of chip images from the
+// originals. Progress is kept in image_regenerations, so an interrupted run
+// continues where it stopped and several processes can share the work.
+type regeneration struct {
+	resolver *graph.Resolver
+	brand    *string
+	// size is empty to regenerate every size
+	size string
+
+	mu     sync.Mutex
+	done   int
+	total  int
+	failed []int
+}
+
+// sizeKey identifies the sizes in image_regenerations
+func (r *regeneration) sizeKey() string {
+	if r.size == "" {
+		return "all"
+	}
+	return r.size
+}
+
+func (r *regeneration) run(ctx context.Context, workers int, restart bool) error {
+	db := r.resolver.DB
+	if restart {
+		_, err := db.Exec(ctx, `DELETE FROM image_regenerations
+		USING chips
+		WHERE chips.id = image_regenerations.chips_id AND image_regenerations.size=$1
+		AND ($2::text IS NULL OR chips.brand_id=$2)`, r.sizeKey(), r.brand)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Add the selected chips, progress from an earlier run is kept
+	_, err := db.Exec(ctx, `INSERT INTO image_regenerations (chips_id, size)
+	SELECT id, $1 FROM chips
+	WHERE image IS NOT NULL AND ($2::text IS NULL OR brand_id=$2)
+	ON CONFLICT DO NOTHING`, r.sizeKey(), r.brand)
+	if err != nil {
+		return err
+	}
+	err = db.QueryRow(ctx, `SELECT count(*), count(image_regenerations.done) FROM image_regenerations
+	JOIN chips ON chips.id = image_regenerations.chips_id
+	WHERE image_regenerations.size=$1 AND chips.image IS NOT NULL
+	AND ($2::text IS NULL OR chips.brand_id=$2)`, r.sizeKey(), r.brand).Scan(&r.total, &r.done)
+	if err != nil {
+		return err
+	}
+	if r.done > 0 {
+		fmt.Printf("Resuming, %d of %d images already done\n", r.done, r.total)
+	} else {
+		fmt.Printf("Regenerating %d images\n", r.total)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				more, err := r.next(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !more {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil && ctx.Err() == nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted after %d of %d images, run again to resume", r.done, r.total)
+	}
+	if len(r.failed) > 0 {
+		return fmt.Errorf("%d images failed, run again to retry them", len(r.failed))
+	}
+
+	// Start from the beginning next time
+	_, err = db.Exec(ctx, `DELETE FROM image_regenerations
+	USING chips
+	WHERE chips.id = image_regenerations.chips_id AND image_regenerations.size=$1
+	AND ($2::text IS NULL OR chips.brand_id=$2)`, r.sizeKey(), r.brand)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Regenerated %d images\n", r.total)
+	return nil
+}
+
+// next claims and regenerates one image, it returns false when none are left
+func (r *regeneration) next(ctx context.Context) (bool, error) {
+	tx, err := r.resolver.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The row stays locked while the image is processed, images that failed
+	// in this run are skipped. Everything is written through tx, since every
+	// worker holds a connection of the pool.
+	r.mu.Lock()
+	failed := append([]int{}, r.failed...)
+	r.mu.Unlock()
+	var id int
+	var image string
+	err = tx.QueryRow(ctx, `SELECT chips.id, chips.image FROM image_regenerations
+	JOIN chips ON chips.id = image_regenerations.chips_id
+	WHERE image_regenerations.size=$1 AND image_regenerations.done IS NULL AND chips.image IS NOT NULL
+	AND ($2::text IS NULL OR chips.brand_id=$2) AND NOT chips.id = ANY($3)
+	ORDER BY chips.id
+	LIMIT 1
+	FOR UPDATE OF image_regenerations SKIP LOCKED`, r.sizeKey(), r.brand, failed).Scan(&id, &image)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var sizes []string
+	if r.size != "" {
+		sizes = []string{r.size}
+	}
+	err = r.resolver.RegenerateImage(ctx, tx, id, image, sizes...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, nil
+		}
+		r.mu.Lock()
+		r.failed = append(r.failed, id)
+		r.mu.Unlock()
+		fmt.Fprintf(os.Stderr, "Failed snacks/%s: %v\n", image, err)
+		return true, nil
+	}
+	_, err = tx.Exec(ctx, `UPDATE image_regenerations SET done=NOW() WHERE chips_id=$1 AND size=$2`, id, r.sizeKey())
+	if err != nil {
+		return false, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	r.done++
+	fmt.Printf("[%d/%d] snacks/%s\n", r.done, r.total, image)
+	r.mu.Unlock()
+	return true, nil
+}
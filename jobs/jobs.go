@@ -0,0 +1,199 @@
+// Package jobs runs background work from a queue stored in Postgres.
+//
+// Workers claim jobs with SELECT ... FOR UPDATE SKIP LOCKED and hold a lease
+// while running them, so a job whose worker dies is picked up again when the
+// lease expires, or fails if that was its last attempt. Failed jobs are
+// retried with exponential backoff until they run out of attempts, after which
+// they are kept in the table for inspection.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/c-wiren/snackstoppen-backend/db"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Job is a unit of work claimed by a worker
+type Job struct {
+	ID          int64
+	Kind        string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+}
+
+// LastAttempt reports whether the job fails for good if this attempt fails
+func (j *Job) LastAttempt() bool {
+	return j.Attempts >= j.MaxAttempts
+}
+
+// Handler runs a job, the job is retried later if an error is returned
+type Handler func(ctx context.Context, job *Job) error
+
+// Enqueue adds a job to the queue. If db is a transaction the job only runs
+// if it commits.
+func Enqueue(ctx context.Context, db db.Execer, kind string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `INSERT INTO jobs (kind, payload) VALUES ($1, $2)`, kind, data)
+	return err
+}
+
+// Queue dispatches queued jobs to handlers by kind
+type Queue struct {
+	DB *pgxpool.Pool
+	// PollInterval is how long idle workers wait before looking for new jobs
+	PollInterval time.Duration
+	// Lease is how long a job may run before another worker may claim it
+	Lease time.Duration
+
+	handlers map[string]Handler
+}
+
+// New creates a queue with default settings
+func New(db *pgxpool.Pool) *Queue {
+	return &Queue{
+		DB:           db,
+		PollInterval: time.Second,
+		Lease:        5 * time.Minute,
+		handlers:     map[string]Handler{},
+	}
+}
+
+// Handle registers the handler for a kind of job
+func (q *Queue) Handle(kind string, handler Handler) {
+	q.handlers[kind] = handler
+}
+
+// Run starts workers and blocks until ctx is cancelled and they have stopped
+func (q *Queue) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		job, err := q.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("jobs: claim failed: %v", err)
+		}
+		if job != nil {
+			q.finish(job, q.run(ctx, job))
+			continue
+		}
+		err = q.failExpired(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("jobs: fail expired jobs failed: %v", err)
+		}
+		// Wait for new jobs
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+// claim locks the next job that is due and takes a lease on it
+func (q *Queue) claim(ctx context.Context) (*Job, error) {
+	job := &Job{}
+	err := q.DB.QueryRow(ctx, `UPDATE jobs
+	SET attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $1)
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE failed IS NULL AND attempts < max_attempts AND run_at <= NOW()
+		AND (locked_until IS NULL OR locked_until < NOW())
+		ORDER BY run_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, kind, payload, attempts, max_attempts`, q.Lease.Seconds()).Scan(
+		&job.ID, &job.Kind, &job.Payload, &job.Attempts, &job.MaxAttempts)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// failExpired fails the jobs whose worker died during their last attempt,
+// since claim never picks them up again
+func (q *Queue) failExpired(ctx context.Context) error {
+	_, err := q.DB.Exec(ctx, `UPDATE jobs
+	SET failed = NOW(), locked_until = NULL, last_error = 'lease expired on the last attempt'
+	WHERE failed IS NULL AND attempts >= max_attempts AND locked_until < NOW()`)
+	return err
+}
+
+// run calls the handler of the job, panics are returned as errors
+func (q *Queue) run(ctx context.Context, job *Job) (err error) {
+	handler, ok := q.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for %s jobs", job.Kind)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, q.Lease)
+	defer cancel()
+	return handler(ctx, job)
+}
+
+// finish removes a completed job or schedules a retry of a failed one. It
+// runs even if the worker is stopping, so results are not lost.
+func (q *Queue) finish(job *Job, jobErr error) {
+	ctx := context.Background()
+	var err error
+	switch {
+	case jobErr == nil:
+		_, err = q.DB.Exec(ctx, `DELETE FROM jobs WHERE id=$1`, job.ID)
+	case job.LastAttempt():
+		log.Printf("jobs: %s job %d failed after %d attempts: %v", job.Kind, job.ID, job.Attempts, jobErr)
+		_, err = q.DB.Exec(ctx, `UPDATE jobs
+		SET failed = NOW(), locked_until = NULL, last_error = $1
+		WHERE id=$2`, jobErr.Error(), job.ID)
+	default:
+		log.Printf("jobs: %s job %d failed, retrying: %v", job.Kind, job.ID, jobErr)
+		_, err = q.DB.Exec(ctx, `UPDATE jobs
+		SET run_at = NOW() + make_interval(secs => $1), locked_until = NULL, last_error = $2
+		WHERE id=$3`, backoff(job.Attempts).Seconds(), jobErr.Error(), job.ID)
+	}
+	if err != nil {
+		log.Printf("jobs: could not finish %s job %d: %v", job.Kind, job.ID, err)
+	}
+}
+
+// backoff returns the delay before the next attempt, doubling from 10 seconds
+// up to an hour with some jitter so failing jobs do not retry in lockstep
+func backoff(attempts int) time.Duration {
+	delay := time.Hour
+	if attempts < 10 {
+		delay = 10 * time.Second << (attempts - 1)
+		if delay > time.Hour {
+			delay = time.Hour
+		}
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/5)+1))
+}
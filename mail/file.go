@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// File writes each message as an .eml file to a directory, for development
+type File struct {
+	Dir string
+}
+
+// NewFile creates a file backend, creating the directory if needed
+func NewFile(dir string) (*File, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Dir: dir}, nil
+}
+
+func (f *File) Send(ctx context.Context, msg Message) error {
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	path := filepath.Join(f.Dir, id+".eml")
+	err := os.WriteFile(path, msg.rfc822(id), 0644)
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote email to %s: %s", msg.To, path)
+	return nil
+}
@@ -0,0 +1,42 @@
+// Package mail sends transactional email through a pluggable backend.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"time"
+)
+
+// From is the sender of all email
+const From = "Snackstoppen <noreply@snackstoppen.se>"
+
+// Message is a single HTML email
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// rfc822 renders the message as a MIME email
+func (msg Message) rfc822(id string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@snackstoppen.se>\r\n", id)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.HTML)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,26 @@
+package mail
+
+import (
+	"context"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// Mailgun sends email through the Mailgun EU API
+type Mailgun struct {
+	mg *mailgun.MailgunImpl
+}
+
+// NewMailgun creates a Mailgun backend for the given domain
+func NewMailgun(domain, apiKey string) *Mailgun {
+	mg := mailgun.NewMailgun(domain, apiKey)
+	mg.SetAPIBase(mailgun.APIBaseEU)
+	return &Mailgun{mg: mg}
+}
+
+func (m *Mailgun) Send(ctx context.Context, msg Message) error {
+	message := m.mg.NewMessage(From, msg.Subject, "", msg.To)
+	message.SetHtml(msg.HTML)
+	_, _, err := m.mg.Send(ctx, message)
+	return err
+}
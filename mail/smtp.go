@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"context"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTP sends email to a plain SMTP server, e.g. a local MailHog sink
+type SMTP struct {
+	Addr string
+	Auth smtp.Auth
+}
+
+// NewSMTP creates an SMTP backend. Authentication is only used if a
+// username is given.
+func NewSMTP(addr, username, password string) *SMTP {
+	s := &SMTP{Addr: addr}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		s.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	from, err := netmail.ParseAddress(From)
+	if err != nil {
+		return err
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, s.Auth, from.Address, []string{msg.To}, msg.rfc822(id))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/c-wiren/snackstoppen-backend/db"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const migrateUsage = `usage: server migrate [up | down [steps] | baseline version | status]`
+
+// migrateCommand runs "server migrate", which applies the embedded schema migrations
+func migrateCommand(ctx context.Context, dbpool *pgxpool.Pool, args []string) {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "up":
+		migrations, err := db.Up(ctx, dbpool)
+		for _, migration := range migrations {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+			os.Exit(1)
+		}
+		if len(migrations) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				os.Exit(2)
+			}
+		}
+		migrations, err := db.Down(ctx, dbpool, steps)
+		for _, migration := range migrations {
+			fmt.Printf("Reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+			os.Exit(1)
+		}
+	case "baseline":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			os.Exit(2)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			os.Exit(2)
+		}
+		migrations, err := db.Baseline(ctx, dbpool, version)
+		for _, migration := range migrations {
+			fmt.Printf("Marked %04d_%s as applied\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Baseline failed: %v\n", err)
+			os.Exit(1)
+		}
+	case "status":
+		migrations, applied, err := db.Status(ctx, dbpool)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read migrations: %v\n", err)
+			os.Exit(1)
+		}
+		for _, migration := range migrations {
+			state := "pending"
+			if applied[migration.Version] {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", migration.Version, migration.Name, state)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,92 @@
+// Package notifications writes the in-app notifications of users.
+//
+// Repeated events are grouped into one notification while it is unread, e.g.
+// every like of a review adds its author to the actors of the same
+// notification. Once read, the next event starts a new notification.
+//
+// Undoing a like or follow removes the actor from the unread notification,
+// and doing it again does not notify if the actor is in a read one, so that
+// liking and unliking does not notify over and over.
+package notifications
+
+import (
+	"context"
+
+	"github.com/c-wiren/snackstoppen-backend/db"
+)
+
+// Kinds of notifications
+const (
+	Like   = "like"
+	Follow = "follow"
+	Review = "review"
+)
+
+// group adds the new actor first to an unread notification of the same kind
+// and review, instead of inserting another one
+const group = `
+	ON CONFLICT (user_id, kind, COALESCE(review_id, 0)) WHERE read IS NULL
+	DO UPDATE SET actor_ids = array_prepend(EXCLUDED.actor_ids[1], array_remove(notifications.actor_ids, EXCLUDED.actor_ids[1])),
+	updated = NOW()`
+
+// notRead leaves out the new notification, selected as inserted, if actor $1
+// is in a read notification of the same user, kind and review
+const notRead = `NOT EXISTS (
+	SELECT 1 FROM notifications
+	WHERE notifications.user_id=inserted.user_id AND notifications.kind=inserted.kind
+	AND COALESCE(notifications.review_id, 0)=COALESCE(inserted.review_id, 0)
+	AND notifications.read IS NOT NULL AND $1 = ANY(notifications.actor_ids)
+)`
+
+// AddLike notifies the author of a review that actor liked it
+func AddLike(ctx context.Context, db db.Execer, actorID int, reviewID int) error {
+	_, err := db.Exec(ctx, `INSERT INTO notifications (user_id, kind, review_id, actor_ids)
+	SELECT inserted.user_id, inserted.kind, inserted.review_id, inserted.actor_ids FROM (
+		SELECT user_id, '`+Like+`' AS kind, id AS review_id, ARRAY[$1::integer] AS actor_ids FROM reviews
+		WHERE id=$2 AND user_id<>$1
+	) AS inserted
+	WHERE `+notRead+group, actorID, reviewID)
+	return err
+}
+
+// RemoveLike removes actor from the unread notification of a like
+func RemoveLike(ctx context.Context, db db.Execer, actorID int, reviewID int) error {
+	_, err := db.Exec(ctx, `DELETE FROM notifications
+	WHERE kind='`+Like+`' AND review_id=$2 AND read IS NULL AND actor_ids = ARRAY[$1::integer]`, actorID, reviewID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `UPDATE notifications SET actor_ids = array_remove(actor_ids, $1)
+	WHERE kind='`+Like+`' AND review_id=$2 AND read IS NULL AND $1 = ANY(actor_ids)`, actorID, reviewID)
+	return err
+}
+
+// AddFollow notifies a user that actor follows them
+func AddFollow(ctx context.Context, db db.Execer, actorID int, userID int) error {
+	_, err := db.Exec(ctx, `INSERT INTO notifications (user_id, kind, actor_ids)
+	SELECT inserted.user_id, inserted.kind, inserted.actor_ids FROM (
+		SELECT $2::integer AS user_id, '`+Follow+`' AS kind, NULL::integer AS review_id, ARRAY[$1::integer] AS actor_ids
+	) AS inserted
+	WHERE `+notRead+group, actorID, userID)
+	return err
+}
+
+// RemoveFollow removes actor from the unread notification of a follow
+func RemoveFollow(ctx context.Context, db db.Execer, actorID int, userID int) error {
+	_, err := db.Exec(ctx, `DELETE FROM notifications
+	WHERE user_id=$2 AND kind='`+Follow+`' AND read IS NULL AND actor_ids = ARRAY[$1::integer]`, actorID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `UPDATE notifications SET actor_ids = array_remove(actor_ids, $1)
+	WHERE user_id=$2 AND kind='`+Follow+`' AND read IS NULL AND $1 = ANY(actor_ids)`, actorID, userID)
+	return err
+}
+
+// AddReview notifies the followers of actor that they posted a review
+func AddReview(ctx context.Context, db db.Execer, actorID int, reviewID int) error {
+	_, err := db.Exec(ctx, `INSERT INTO notifications (user_id, kind, review_id, actor_ids)
+	SELECT user_id, '`+Review+`', $2, ARRAY[$1::integer] FROM follows
+	WHERE follows_user_id=$1`+group, actorID, reviewID)
+	return err
+}
@@ -0,0 +1,167 @@
+// Package pubsub delivers events to the subscribers of every server with
+// Postgres LISTEN/NOTIFY.
+//
+// Events are sent with pg_notify on Channel, by Publish or from SQL such as
+// triggers, and are delivered when the transaction that sent them commits.
+// Delivery is best effort: events sent while the listening connection
+// reconnects are lost, and subscribers that fall behind miss events instead of
+// holding up the others.
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/c-wiren/snackstoppen-backend/db"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Channel is the Postgres notification channel of events
+const Channel = "events"
+
+// ReviewsTopic has an event for every new review by the user
+func ReviewsTopic(userID int) string {
+	return fmt.Sprintf("reviews:%d", userID)
+}
+
+// LikesTopic has an event every time the review is liked
+func LikesTopic(reviewID int) string {
+	return fmt.Sprintf("likes:%d", reviewID)
+}
+
+// NotificationsTopic has an event every time a notification of the user is
+// added or grouped with a new actor, sent by a trigger on notifications
+func NotificationsTopic(userID int) string {
+	return fmt.Sprintf("notifications:%d", userID)
+}
+
+// UsersTopic has an event every time the role of the user changes or they are
+// banned
+func UsersTopic(userID int) string {
+	return fmt.Sprintf("users:%d", userID)
+}
+
+// Event is the payload of a notification, ID is the review, notification etc.
+// that the event is about
+type Event struct {
+	Topic string `json:"topic"`
+	ID    int    `json:"id"`
+}
+
+// How long to wait before listening again after the connection failed
+const reconnectDelay = 5 * time.Second
+
+// Events waiting for a slow subscriber, later events are dropped
+const subscriberBuffer = 16
+
+// Publish sends an event. If db is a transaction it is sent on commit.
+func Publish(ctx context.Context, db db.Execer, topic string, id int) error {
+	payload, err := json.Marshal(Event{Topic: topic, ID: id})
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `SELECT pg_notify($1, $2)`, Channel, string(payload))
+	return err
+}
+
+// Broker listens for events and passes them on to subscribers
+type Broker struct {
+	db *pgxpool.Pool
+
+	mu          sync.Mutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+// New creates a broker that listens on a connection of db once Run is called
+func New(db *pgxpool.Pool) *Broker {
+	return &Broker{db: db, subscribers: map[string]map[chan Event]struct{}{}}
+}
+
+// Subscribe returns the events of every topic until ctx is cancelled, when
+// the channel is closed
+func (b *Broker) Subscribe(ctx context.Context, topics ...string) <-chan Event {
+	events := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	for _, topic := range topics {
+		if b.subscribers[topic] == nil {
+			b.subscribers[topic] = map[chan Event]struct{}{}
+		}
+		b.subscribers[topic][events] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		for _, topic := range topics {
+			delete(b.subscribers[topic], events)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+		}
+		close(events)
+		b.mu.Unlock()
+	}()
+	return events
+}
+
+// Run listens for events until ctx is cancelled, reconnecting when the
+// connection fails
+func (b *Broker) Run(ctx context.Context) {
+	for {
+		err := b.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("pubsub: listen failed: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// listen holds a connection of the pool for as long as it receives events
+func (b *Broker) listen(ctx context.Context) error {
+	conn, err := b.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection is closed instead of returned to the pool still listening
+	defer conn.Release()
+	defer conn.Conn().Close(context.Background())
+
+	_, err = conn.Exec(ctx, `LISTEN `+Channel)
+	if err != nil {
+		return err
+	}
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var event Event
+		err = json.Unmarshal([]byte(notification.Payload), &event)
+		if err != nil {
+			log.Printf("pubsub: invalid event %q: %v", notification.Payload, err)
+			continue
+		}
+		b.dispatch(event)
+	}
+}
+
+func (b *Broker) dispatch(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events := range b.subscribers[event.Topic] {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
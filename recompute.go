@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/c-wiren/snackstoppen-backend/stats"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const recomputeUsage = `usage: server recompute-stats [--dry-run]`
+
+// recomputeCommand runs "server recompute-stats", which rebuilds the chip
+// ratings, review counts and brand counts and reports the ones that drifted
+func recomputeCommand(ctx context.Context, dbpool *pgxpool.Pool, args []string) {
+	flags := flag.NewFlagSet("recompute-stats", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report drift, do not fix it")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, recomputeUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	drifts, err := stats.Recompute(ctx, dbpool, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Recompute failed: %v\n", err)
+		os.Exit(1)
+	}
+	for _, drift := range drifts {
+		fmt.Println(drift)
+	}
+	switch {
+	case len(drifts) == 0:
+		fmt.Println("No drift, all stats are up to date")
+	case *dryRun:
+		fmt.Printf("Found %d drifted stats\n", len(drifts))
+	default:
+		fmt.Printf("Fixed %d drifted stats\n", len(drifts))
+	}
+}
@@ -6,23 +6,42 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/c-wiren/snackstoppen-backend/apperr"
 	"github.com/c-wiren/snackstoppen-backend/auth"
+	"github.com/c-wiren/snackstoppen-backend/dataloader"
 	"github.com/c-wiren/snackstoppen-backend/graph"
 	"github.com/c-wiren/snackstoppen-backend/graph/generated"
+	"github.com/c-wiren/snackstoppen-backend/jobs"
+	"github.com/c-wiren/snackstoppen-backend/mail"
+	"github.com/c-wiren/snackstoppen-backend/pubsub"
+	"github.com/c-wiren/snackstoppen-backend/stats"
+	"github.com/c-wiren/snackstoppen-backend/storage"
+	"github.com/c-wiren/snackstoppen-backend/suggest"
 	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/mailgun/mailgun-go/v4"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/rs/cors"
 )
 
 const defaultPort = "5000"
 const dbURLDev = "postgresql://localhost/snackstoppen_dev"
 const mailgunDomain = "mg.snackstoppen.se"
+const mailDirDev = "tmp/mail"
+const imageDirDev = "tmp/images"
+const s3HostDefault = "static.snackstoppen.se"
+const s3BucketDefault = "snackstoppen"
+const defaultJobWorkers = 2
+const suggestRefreshInterval = 5 * time.Minute
+const websocketKeepAlive = 10 * time.Second
 
 var dev bool
 
@@ -32,10 +51,21 @@ func main() {
 	if port == "" {
 		port = defaultPort
 	}
+	var err error
 	secret := os.Getenv("SECRET")
 	if secret != "" {
 		auth.Secret = secret
 	}
+	auth.TrustedProxies, err = auth.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid TRUSTED_PROXIES: %v\n", err)
+		os.Exit(2)
+	}
+	err = configureScorePrior()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid score prior: %v\n", err)
+		os.Exit(2)
+	}
 	dbURL := os.Getenv("DATABASE_URL")
 	if dev {
 		dbURL = dbURLDev
@@ -48,31 +78,80 @@ func main() {
 	defer dbpool.Close()
 	log.Printf("Connected to DB")
 
-	mg := mailgun.NewMailgun(mailgunDomain, os.Getenv("MAILGUN_KEY"))
-	mg.SetAPIBase(mailgun.APIBaseEU)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			migrateCommand(context.Background(), dbpool, os.Args[2:])
+		case "recompute-stats":
+			recomputeCommand(context.Background(), dbpool, os.Args[2:])
+		case "images":
+			images, err := newImageStore(port)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to create image store: %v\n", err)
+				os.Exit(1)
+			}
+			imagesCommand(context.Background(), &graph.Resolver{DB: dbpool, Images: images}, os.Args[2:])
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command %s\n", os.Args[1])
+			os.Exit(2)
+		}
+		return
+	}
+
+	mailer, err := newMailer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create mailer: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Initialize minio client object.
-	minioClient, err := minio.New("static.snackstoppen.se", &minio.Options{
-		Creds:  credentials.NewStaticV4(os.Getenv("S3_ACCESS"), os.Getenv("S3_SECRET"), ""),
-		Secure: true,
-	})
+	images, err := newImageStore(port)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to create minio client: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Unable to create image store: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Autocomplete index, rebuilt after changes and every few minutes to pick
+	// up changes made by other servers
+	suggestions := suggest.New(dbpool, suggestRefreshInterval)
+	err = suggestions.Load(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to build suggest index: %v\n", err)
+		os.Exit(1)
+	}
+	go suggestions.Run(context.Background())
+
+	// Events of subscriptions, sent through the database to reach every server
+	broker := pubsub.New(dbpool)
+	go broker.Run(context.Background())
+
+	resolver := &graph.Resolver{DB: dbpool, Mailer: mailer, Images: images, Suggestions: suggestions, PubSub: broker}
+
+	// Process background jobs, e.g. resizing uploaded images
+	queue := jobs.New(dbpool)
+	resolver.RegisterJobs(queue)
+	go queue.Run(context.Background(), jobWorkers())
+
 	router := chi.NewRouter()
 	router.Use(cors.New(cors.Options{
 		AllowCredentials: true,
 		AllowedHeaders:   []string{"Origin", "X-Requested-With", "Content-Type", "Accept", "Authorization"},
 	}).Handler)
 
-	router.Use(auth.Middleware())
-	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: &graph.Resolver{DB: dbpool, Mailgun: mg, S3: minioClient}}))
+	router.Use(auth.Middleware(dbpool))
+	router.Use(dataloader.Middleware(dbpool))
+	srv := newGraphQLServer(dbpool, broker, generated.NewExecutableSchema(generated.Config{
+		Resolvers:  resolver,
+		Directives: generated.DirectiveRoot{HasPermission: graph.HasPermission},
+	}))
+	srv.SetErrorPresenter(apperr.Presenter)
+	srv.SetRecoverFunc(apperr.Recover)
 	if dev {
 		router.Handle("/", playground.Handler("GraphQL playground", "/graphql"))
 	}
 	router.Handle("/graphql", srv)
+	if local, ok := images.(*storage.Local); ok && dev {
+		router.Handle("/images/*", http.StripPrefix("/images", local.Handler()))
+	}
 
 	log.Printf("Server listening on port %s", port)
 	if dev {
@@ -81,3 +160,126 @@ func main() {
 
 	log.Fatal(http.ListenAndServe(":"+port, router))
 }
+
+// newGraphQLServer is handler.NewDefaultServer with websocket connections
+// authenticated by auth.WebsocketInit
+func newGraphQLServer(db *pgxpool.Pool, broker *pubsub.Broker, schema graphql.ExecutableSchema) *handler.Server {
+	srv := handler.New(schema)
+	srv.AddTransport(transport.Websocket{
+		// Browsers connect from the site's origin, requests are authenticated
+		// with the token in the init payload and not with cookies
+		Upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		InitFunc:              auth.WebsocketInit(db, broker),
+		KeepAlivePingInterval: websocketKeepAlive,
+	})
+	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.MultipartForm{})
+
+	srv.SetQueryCache(lru.New(1000))
+
+	srv.Use(extension.Introspection{})
+	srv.Use(extension.AutomaticPersistedQuery{
+		Cache: lru.New(100),
+	})
+	return srv
+}
+
+// newMailer picks a mail backend from MAIL_BACKEND (mailgun, smtp or file).
+// Defaults to mailgun in production and file in development.
+func newMailer() (mail.Mailer, error) {
+	backend := os.Getenv("MAIL_BACKEND")
+	if backend == "" {
+		backend = "mailgun"
+		if dev {
+			backend = "file"
+		}
+	}
+	switch backend {
+	case "mailgun":
+		return mail.NewMailgun(mailgunDomain, os.Getenv("MAILGUN_KEY")), nil
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			addr = "localhost:1025"
+		}
+		return mail.NewSMTP(addr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")), nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = mailDirDev
+		}
+		return mail.NewFile(dir)
+	default:
+		return nil, fmt.Errorf("unknown MAIL_BACKEND %q", backend)
+	}
+}
+
+// newImageStore picks an image backend from IMAGE_STORE (s3 or local).
+// Defaults to s3 in production and local in development.
+func newImageStore(port string) (storage.ImageStore, error) {
+	backend := os.Getenv("IMAGE_STORE")
+	if backend == "" {
+		backend = "s3"
+		if dev {
+			backend = "local"
+		}
+	}
+	switch backend {
+	case "s3":
+		host := os.Getenv("S3_HOST")
+		if host == "" {
+			host = s3HostDefault
+		}
+		bucket := os.Getenv("S3_BUCKET")
+		if bucket == "" {
+			bucket = s3BucketDefault
+		}
+		secure := os.Getenv("S3_INSECURE") == ""
+		return storage.NewS3(host, bucket, os.Getenv("S3_ACCESS"), os.Getenv("S3_SECRET"), secure, os.Getenv("IMAGE_BASE_URL"))
+	case "local":
+		dir := os.Getenv("IMAGE_DIR")
+		if dir == "" {
+			dir = imageDirDev
+		}
+		baseURL := os.Getenv("IMAGE_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:" + port + "/images"
+		}
+		return storage.NewLocal(dir, baseURL)
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_STORE %q", backend)
+	}
+}
+
+// jobWorkers returns the number of background job workers from JOB_WORKERS
+func jobWorkers() int {
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers < 1 {
+		return defaultJobWorkers
+	}
+	return workers
+}
+
+// configureScorePrior reads the prior of the chip score from SCORE_PRIOR_MEAN
+// and SCORE_PRIOR_WEIGHT, see stats.Prior
+func configureScorePrior() error {
+	if mean := os.Getenv("SCORE_PRIOR_MEAN"); mean != "" {
+		value, err := strconv.ParseFloat(mean, 64)
+		if err != nil || value < 1 || value > 10 {
+			return fmt.Errorf("SCORE_PRIOR_MEAN must be a rating between 1 and 10")
+		}
+		stats.ScorePrior.Mean = value
+	}
+	if weight := os.Getenv("SCORE_PRIOR_WEIGHT"); weight != "" {
+		value, err := strconv.ParseFloat(weight, 64)
+		if err != nil || value < 0 {
+			return fmt.Errorf("SCORE_PRIOR_WEIGHT must be a non-negative number")
+		}
+		stats.ScorePrior.Weight = value
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+// Package stats maintains the denormalized statistics of chips and brands,
+// chips.rating, chips.reviews, chips.score, the flavor sums of chips and
+// brands.count.
+//
+// Review writes update the chip in the same transaction with AddReview,
+// RemoveReview and ChangeScores, hidden reviews are not counted. The updates
+// are relative to the stored values, so concurrent transactions are
+// serialized by the row lock on the chip instead of overwriting each other.
+// Recompute rebuilds everything from the reviews and chips and reports what
+// had drifted.
+package stats
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/c-wiren/snackstoppen-backend/db"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Prior is what a chip is assumed to be rated before it has any reviews. The
+// score of a chip is its average rating pulled towards Mean, as if it also had
+// Weight reviews rating Mean. A few high ratings then rank lower than many
+// slightly lower ones.
+type Prior struct {
+	Mean   float64
+	Weight float64
+}
+
+// ScorePrior is the prior used for chips.score, run Recompute after changing it
+var ScorePrior = Prior{Mean: 6, Weight: 5}
+
+// FlavorDimensions are the optional flavor sub-scores of reviews, rated 1-5.
+// A review column such as crunch is summed up in crunch_sum and crunch_count
+// of the chip.
+var FlavorDimensions = [...]string{"crunch", "saltiness", "flavor_intensity", "greasiness", "value"}
+
+// Scores are the rating and flavor sub-scores of a review, in the order of
+// FlavorDimensions. Sub-scores that were not given are nil.
+type Scores struct {
+	Rating int
+	Flavor [len(FlavorDimensions)]*int
+}
+
+// AddReview counts a new review of a chip
+func AddReview(ctx context.Context, db db.Execer, chipID int, scores Scores) error {
+	return updateChip(ctx, db, chipID, 1, Scores{}, scores)
+}
+
+// RemoveReview uncounts a deleted review of a chip
+func RemoveReview(ctx context.Context, db db.Execer, chipID int, scores Scores) error {
+	return updateChip(ctx, db, chipID, -1, scores, Scores{})
+}
+
+// ChangeScores updates a chip when the scores of one of its reviews change
+func ChangeScores(ctx context.Context, db db.Execer, chipID int, oldScores Scores, newScores Scores) error {
+	return updateChip(ctx, db, chipID, 0, oldScores, newScores)
+}
+
+// updateChip adds reviews to the review count and the difference between the
+// old and new scores to the sums
+func updateChip(ctx context.Context, db db.Execer, chipID int, reviews int, oldScores Scores, newScores Scores) error {
+	args := []interface{}{chipID, reviews, newScores.Rating - oldScores.Rating, ScorePrior.Mean, ScorePrior.Weight}
+	q := `UPDATE chips
+	SET reviews = reviews + $2,
+	rating_sum = rating_sum + $3,
+	rating = CASE WHEN reviews + $2 > 0 THEN (rating_sum + $3)::double precision / (reviews + $2) ELSE 0 END,
+	score = ` + score("reviews + $2", "rating_sum + $3", "$4", "$5")
+	for i, dimension := range FlavorDimensions {
+		sum, count := 0, 0
+		if oldScores.Flavor[i] != nil {
+			sum -= *oldScores.Flavor[i]
+			count--
+		}
+		if newScores.Flavor[i] != nil {
+			sum += *newScores.Flavor[i]
+			count++
+		}
+		if sum == 0 && count == 0 {
+			continue
+		}
+		args = append(args, sum, count)
+		q += fmt.Sprintf(`,
+	%[1]s_sum = %[1]s_sum + $%[2]d, %[1]s_count = %[1]s_count + $%[3]d`, dimension, len(args)-1, len(args))
+	}
+	_, err := db.Exec(ctx, q+`
+	WHERE id=$1`, args...)
+	return err
+}
+
+// score is the SQL expression of the Bayesian average, null without reviews
+func score(reviews, ratingSum, mean, weight string) string {
+	return fmt.Sprintf(`CASE WHEN %[1]s > 0 THEN (%[4]s::double precision * %[3]s::double precision + %[2]s) / (%[4]s::double precision + %[1]s) END`,
+		reviews, ratingSum, mean, weight)
+}
+
+// brandCategories is the categories of a brand as a JSON object with the
+// subcategories of every category, e.g. {"chips": ["dill", "sourcream"], "ostbågar": []}
+const brandCategories = `(
+	SELECT json_object_agg(category, subcategories) FROM (
+		SELECT category, COALESCE(json_agg(DISTINCT subcategory) FILTER (WHERE subcategory IS NOT NULL), '[]') AS subcategories
+		FROM chips WHERE chips.brand_id=brands.id
+		GROUP BY category
+	) AS c
+)`
+
+const brandCount = `(SELECT COUNT(*) FROM chips WHERE chips.brand_id=brands.id)`
+
+// RefreshBrands recomputes the chip count and categories of brands, it is
+// called after chips are added, moved or removed
+func RefreshBrands(ctx context.Context, db db.Execer, ids ...string) error {
+	_, err := db.Exec(ctx, `UPDATE brands
+	SET count = `+brandCount+`,
+	categories = `+brandCategories+`
+	WHERE id = ANY($1)`, ids)
+	return err
+}
+
+// Drift is a stored statistic that did not match what it is computed from
+type Drift struct {
+	Table  string
+	ID     string
+	Column string
+	Stored string
+	Actual string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s: %s is %s, should be %s", d.Table, d.ID, d.Column, d.Stored, d.Actual)
+}
+
+// countColumns are the integer statistics of chips
+var countColumns = func() []string {
+	columns := []string{"reviews", "rating_sum"}
+	for _, dimension := range FlavorDimensions {
+		columns = append(columns, dimension+"_sum", dimension+"_count")
+	}
+	return columns
+}()
+
+// actualChips computes the statistics of every chip from its reviews that are
+// not hidden, with the prior as $1 and $2
+var actualChips = func() string {
+	q := `SELECT chips.id, COUNT(reviews.id)::integer AS reviews, COALESCE(SUM(reviews.rating), 0)::integer AS rating_sum,
+	CASE WHEN COUNT(reviews.id) > 0 THEN SUM(reviews.rating)::double precision / COUNT(reviews.id) ELSE 0 END AS rating,
+	` + score("COUNT(reviews.id)", "COALESCE(SUM(reviews.rating), 0)", "$1", "$2") + ` AS score`
+	for _, dimension := range FlavorDimensions {
+		q += fmt.Sprintf(`,
+	COALESCE(SUM(reviews.%[1]s), 0)::integer AS %[1]s_sum, COUNT(reviews.%[1]s)::integer AS %[1]s_count`, dimension)
+	}
+	return q + `
+	FROM chips LEFT JOIN reviews ON reviews.chips_id = chips.id AND reviews.hidden IS NULL
+	GROUP BY chips.id`
+}()
+
+// chipDrifted compares the chips row with the actual statistics a
+var chipDrifted = func() string {
+	var conditions []string
+	for _, column := range countColumns {
+		conditions = append(conditions, fmt.Sprintf("chips.%[1]s <> a.%[1]s", column))
+	}
+	conditions = append(conditions, "abs(chips.rating - a.rating) > 1e-9",
+		"(chips.score IS NULL) <> (a.score IS NULL)", "abs(chips.score - a.score) > 1e-9")
+	return strings.Join(conditions, " OR ")
+}()
+
+// Recompute rebuilds the statistics of every chip and brand and returns the
+// ones that were wrong. If dryRun is set nothing is updated.
+func Recompute(ctx context.Context, db *pgxpool.Pool, dryRun bool) ([]Drift, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Block chip writes while recomputing. Reviews written meanwhile update
+	// their chip after the lock is released, on top of the recomputed value.
+	_, err = tx.Exec(ctx, `LOCK TABLE chips, brands IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []Drift
+	q := `SELECT chips.id, chips.rating, a.rating, chips.score, a.score`
+	for _, column := range countColumns {
+		q += fmt.Sprintf(", chips.%[1]s, a.%[1]s", column)
+	}
+	rows, err := tx.Query(ctx, q+`
+	FROM chips JOIN (`+actualChips+`) AS a ON a.id = chips.id
+	WHERE `+chipDrifted+`
+	ORDER BY chips.id`, ScorePrior.Mean, ScorePrior.Weight)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var rating, actualRating float64
+		var score, actualScore *float64
+		counts := make([]int, 2*len(countColumns))
+		dest := []interface{}{&id, &rating, &actualRating, &score, &actualScore}
+		for i := range counts {
+			dest = append(dest, &counts[i])
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, chipDrifts(id, rating, actualRating, score, actualScore, counts)...)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	rows, err = tx.Query(ctx, `SELECT id, count, `+brandCount+`, COALESCE(categories::text, 'null'), COALESCE(`+brandCategories+`::text, 'null'),
+	categories::jsonb IS DISTINCT FROM `+brandCategories+`::jsonb
+	FROM brands
+	WHERE count <> `+brandCount+` OR categories::jsonb IS DISTINCT FROM `+brandCategories+`::jsonb
+	ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, categories, actualCategories string
+		var count, actualCount int
+		var categoriesDrifted bool
+		err = rows.Scan(&id, &count, &actualCount, &categories, &actualCategories, &categoriesDrifted)
+		if err != nil {
+			return nil, err
+		}
+		if count != actualCount {
+			drifts = append(drifts, Drift{"brands", id, "count", fmt.Sprint(count), fmt.Sprint(actualCount)})
+		}
+		if categoriesDrifted {
+			drifts = append(drifts, Drift{"brands", id, "categories", categories, actualCategories})
+		}
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	if dryRun || len(drifts) == 0 {
+		return drifts, nil
+	}
+	set := "rating = a.rating, score = a.score"
+	for _, column := range countColumns {
+		set += fmt.Sprintf(", %[1]s = a.%[1]s", column)
+	}
+	_, err = tx.Exec(ctx, `UPDATE chips
+	SET `+set+`
+	FROM (`+actualChips+`) AS a
+	WHERE a.id = chips.id AND (`+chipDrifted+`)`, ScorePrior.Mean, ScorePrior.Weight)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `UPDATE brands
+	SET count = `+brandCount+`,
+	categories = `+brandCategories)
+	if err != nil {
+		return nil, err
+	}
+	return drifts, tx.Commit(ctx)
+}
+
+// chipDrifts compares the stored and actual statistics of a chip, counts has
+// the stored and actual value of every count column in turn
+func chipDrifts(id int, rating, actualRating float64, score, actualScore *float64, counts []int) []Drift {
+	var drifts []Drift
+	chip := fmt.Sprint(id)
+	for i, column := range countColumns {
+		if counts[2*i] != counts[2*i+1] {
+			drifts = append(drifts, Drift{"chips", chip, column, fmt.Sprint(counts[2*i]), fmt.Sprint(counts[2*i+1])})
+		}
+	}
+	if math.Abs(rating-actualRating) > 1e-9 {
+		drifts = append(drifts, Drift{"chips", chip, "rating", fmt.Sprintf("%.4g", rating), fmt.Sprintf("%.4g", actualRating)})
+	}
+	if (score == nil) != (actualScore == nil) || (score != nil && math.Abs(*score-*actualScore) > 1e-9) {
+		drifts = append(drifts, Drift{"chips", chip, "score", formatScore(score), formatScore(actualScore)})
+	}
+	return drifts
+}
+
+func formatScore(score *float64) string {
+	if score == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%.4g", *score)
+}
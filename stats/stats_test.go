@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChipDrifts(t *testing.T) {
+	float := func(f float64) *float64 { return &f }
+	// counts returns every count column equal to 4, except the ones in drifted
+	// which are stored with a different value
+	counts := func(drifted map[string]int) []int {
+		result := make([]int, 2*len(countColumns))
+		for i, column := range countColumns {
+			result[2*i], result[2*i+1] = 4, 4
+			if stored, ok := drifted[column]; ok {
+				result[2*i] = stored
+			}
+		}
+		return result
+	}
+	tests := []struct {
+		name                 string
+		rating, actualRating float64
+		score, actualScore   *float64
+		counts               []int
+		want                 []Drift
+	}{
+		{"unchanged", 3.5, 3.5, float(3.2), float(3.2), counts(nil), nil},
+		{"without reviews", 0, 0, nil, nil, counts(nil), nil},
+		{"rounding", 3.5, 3.5 + 1e-12, float(3.2), float(3.2 - 1e-12), counts(nil), nil},
+		{"counts", 3.5, 3.5, float(3.2), float(3.2), counts(map[string]int{"reviews": 5, "crunch_count": 0}), []Drift{
+			{"chips", "7", "reviews", "5", "4"},
+			{"chips", "7", "crunch_count", "0", "4"},
+		}},
+		{"rating", 3.5, 3.25, float(3.2), float(3.2), counts(nil), []Drift{
+			{"chips", "7", "rating", "3.5", "3.25"},
+		}},
+		{"score", 3.5, 3.5, float(3.2), float(3.1), counts(nil), []Drift{
+			{"chips", "7", "score", "3.2", "3.1"},
+		}},
+		{"score without reviews", 3.5, 3.5, float(3.2), nil, counts(nil), []Drift{
+			{"chips", "7", "score", "3.2", "null"},
+		}},
+		{"score missing", 3.5, 3.5, nil, float(3.2), counts(nil), []Drift{
+			{"chips", "7", "score", "null", "3.2"},
+		}},
+		{"everything", 2, 4, nil, float(3.123456), counts(map[string]int{"rating_sum": 8, "value_sum": 1}), []Drift{
+			{"chips", "7", "rating_sum", "8", "4"},
+			{"chips", "7", "value_sum", "1", "4"},
+			{"chips", "7", "rating", "2", "4"},
+			{"chips", "7", "score", "null", "3.123"},
+		}},
+	}
+	for _, test := range tests {
+		got := chipDrifts(7, test.rating, test.actualRating, test.score, test.actualScore, test.counts)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: chipDrifts = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDriftString(t *testing.T) {
+	tests := []struct {
+		drift Drift
+		want  string
+	}{
+		{Drift{"chips", "7", "reviews", "5", "4"}, "chips 7: reviews is 5, should be 4"},
+		{Drift{"chips", "7", "score", "null", "3.2"}, "chips 7: score is null, should be 3.2"},
+		{Drift{"brands", "estrella", "categories", "null", `{"chips" : ["dill"]}`}, `brands estrella: categories is null, should be {"chips" : ["dill"]}`},
+	}
+	for _, test := range tests {
+		if got := test.drift.String(); got != test.want {
+			t.Errorf("String() = %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 stores images in an S3 compatible bucket
+type S3 struct {
+	client  *minio.Client
+	bucket  string
+	baseURL string
+}
+
+// NewS3 connects to host and stores objects in bucket. Objects are publicly
+// served from baseURL, or from https://host/bucket if baseURL is empty.
+func NewS3(host, bucket, accessKey, secretKey string, secure bool, baseURL string) (*S3, error) {
+	client, err := minio.New(host, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: secure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if baseURL == "" {
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		baseURL = scheme + "://" + host + "/" + bucket
+	}
+	return &S3{client: client, bucket: bucket, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, Stat makes the request so missing objects are detected
+	_, err = obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) URL(key string) string {
+	return s.baseURL + "/" + key
+}
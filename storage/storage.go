@@ -0,0 +1,23 @@
+// Package storage stores uploaded images in S3 or a local directory.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by Get if the object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ImageStore stores objects by key, e.g. md/snacks/x.png
+type ImageStore interface {
+	// Put stores size bytes from r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object for reading
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object, it is not an error if it does not exist
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL of the object
+	URL(key string) string
+}
@@ -0,0 +1,289 @@
+// Package suggest answers autocomplete queries from an in-memory prefix index
+// of chips, brands and users.
+//
+// The index is built from the database at startup and rebuilt in the
+// background, after Invalidate is called and periodically to pick up changes
+// made by other servers. Lookups never wait for a rebuild, they use the
+// previous index until the new one is ready.
+package suggest
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Kind is the type of a suggestion, named like the search result types
+type Kind string
+
+const (
+	Chip  Kind = "CHIP"
+	Brand Kind = "BRAND"
+	User  Kind = "USER"
+)
+
+// Entry is something that can be suggested
+type Entry struct {
+	Kind Kind
+	// ID is the id of the chip, brand or user
+	ID    string
+	Label string
+	// Detail is the brand name of a chip or the full name of a user
+	Detail string
+	// Brand and Slug identify a chip
+	Brand string
+	Slug  string
+
+	// weight orders entries that match equally well, e.g. the number of
+	// reviews of a chip
+	weight int
+}
+
+// posting is a word of an entry
+type posting struct {
+	word  string
+	entry int
+}
+
+// index is an immutable snapshot, words are sorted so that the words with a
+// prefix are found by binary search
+type index struct {
+	entries []Entry
+	words   []posting
+	// labels and entryWords are the lower case label and the words of every
+	// entry, computed once for lookups
+	labels     []string
+	entryWords [][]string
+}
+
+// Index is the prefix index of a database
+type Index struct {
+	db       *pgxpool.Pool
+	current  atomic.Pointer[index]
+	rebuild  chan struct{}
+	interval time.Duration
+}
+
+// New creates an empty index of db that is rebuilt at least every interval
+// once Run is called
+func New(db *pgxpool.Pool, interval time.Duration) *Index {
+	i := &Index{db: db, rebuild: make(chan struct{}, 1), interval: interval}
+	i.current.Store(&index{})
+	return i
+}
+
+// Load builds the index, it is called before serving requests
+func (i *Index) Load(ctx context.Context) error {
+	idx, err := build(ctx, i.db)
+	if err != nil {
+		return err
+	}
+	i.current.Store(idx)
+	return nil
+}
+
+// Invalidate schedules a rebuild after chips, brands or users have changed.
+// It does not block, and calls made during a rebuild cause one more rebuild.
+// A nil index is ignored, so commands without an index can share resolvers.
+func (i *Index) Invalidate() {
+	if i == nil {
+		return
+	}
+	select {
+	case i.rebuild <- struct{}{}:
+	default:
+	}
+}
+
+// Run rebuilds the index when invalidated and every interval until ctx is
+// cancelled
+func (i *Index) Run(ctx context.Context) {
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-i.rebuild:
+		case <-ticker.C:
+		}
+		err := i.Load(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("suggest: rebuild failed: %v", err)
+		}
+	}
+}
+
+// Lookup returns at most limit entries where every word of prefix starts a
+// word of the entry. Entries whose label starts with the prefix come first,
+// then the most popular.
+func (i *Index) Lookup(prefix string, limit int) []Entry {
+	terms := words(prefix)
+	if i == nil || len(terms) == 0 || limit <= 0 {
+		return nil
+	}
+	idx := i.current.Load()
+
+	// Candidates come from the longest term, which matches the fewest words
+	longest := terms[0]
+	for _, term := range terms[1:] {
+		if len(term) > len(longest) {
+			longest = term
+		}
+	}
+	lowerPrefix := strings.ToLower(strings.TrimSpace(prefix))
+	better := func(a, b int) bool {
+		if sa, sb := strings.HasPrefix(idx.labels[a], lowerPrefix), strings.HasPrefix(idx.labels[b], lowerPrefix); sa != sb {
+			return sa
+		}
+		ea, eb := &idx.entries[a], &idx.entries[b]
+		if ea.weight != eb.weight {
+			return ea.weight > eb.weight
+		}
+		return ea.Label < eb.Label
+	}
+
+	// Keep the best limit matches in order, short prefixes match a large part
+	// of the index so the matches are not collected and sorted
+	seen := make([]uint64, (len(idx.entries)+63)/64)
+	var best []int
+	for _, p := range idx.withPrefix(longest) {
+		if seen[p.entry/64]&(1<<(p.entry%64)) != 0 {
+			continue
+		}
+		seen[p.entry/64] |= 1 << (p.entry % 64)
+		if len(terms) > 1 && !idx.matchesAll(p.entry, terms) {
+			continue
+		}
+		if len(best) == limit && !better(p.entry, best[limit-1]) {
+			continue
+		}
+		j := sort.Search(len(best), func(j int) bool { return better(p.entry, best[j]) })
+		if len(best) < limit {
+			best = append(best, 0)
+		}
+		copy(best[j+1:], best[j:])
+		best[j] = p.entry
+	}
+
+	entries := make([]Entry, len(best))
+	for j, entry := range best {
+		entries[j] = idx.entries[entry]
+	}
+	return entries
+}
+
+// withPrefix returns the postings of every word starting with prefix
+func (idx *index) withPrefix(prefix string) []posting {
+	start := sort.Search(len(idx.words), func(j int) bool { return idx.words[j].word >= prefix })
+	end := start
+	for end < len(idx.words) && strings.HasPrefix(idx.words[end].word, prefix) {
+		end++
+	}
+	return idx.words[start:end]
+}
+
+// matchesAll reports whether every term starts a word of the entry
+func (idx *index) matchesAll(entry int, terms []string) bool {
+	for _, term := range terms {
+		found := false
+		for _, word := range idx.entryWords[entry] {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// words splits text into lower case words, anything but letters and digits
+// separates words
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// build reads every chip, brand and user into a new index
+func build(ctx context.Context, db *pgxpool.Pool) (*index, error) {
+	var entries []Entry
+	rows, err := db.Query(ctx, `SELECT chips.id::text, chips.name, brands.name, chips.brand_id, chips.slug, chips.reviews
+	FROM chips INNER JOIN brands ON chips.brand_id=brands.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry := Entry{Kind: Chip}
+		err = rows.Scan(&entry.ID, &entry.Label, &entry.Detail, &entry.Brand, &entry.Slug, &entry.weight)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	rows, err = db.Query(ctx, `SELECT id, name, count FROM brands`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry := Entry{Kind: Brand}
+		err = rows.Scan(&entry.ID, &entry.Label, &entry.weight)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	rows, err = db.Query(ctx, `SELECT id::text, username, TRIM(COALESCE(firstname, '') || ' ' || COALESCE(lastname, '')), followers
+	FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry := Entry{Kind: User}
+		err = rows.Scan(&entry.ID, &entry.Label, &entry.Detail, &entry.weight)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return newIndex(entries), nil
+}
+
+// newIndex indexes entries by the words of their label and detail
+func newIndex(entries []Entry) *index {
+	idx := &index{entries: entries}
+	for j, entry := range idx.entries {
+		entryWords := append(words(entry.Label), words(entry.Detail)...)
+		for _, word := range entryWords {
+			idx.words = append(idx.words, posting{word, j})
+		}
+		idx.labels = append(idx.labels, strings.ToLower(entry.Label))
+		idx.entryWords = append(idx.entryWords, entryWords)
+	}
+	sort.Slice(idx.words, func(a, b int) bool { return idx.words[a].word < idx.words[b].word })
+	return idx
+}
@@ -0,0 +1,122 @@
+package suggest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testIndex is an index of entries without a database
+func testIndex(entries []Entry) *Index {
+	i := New(nil, time.Hour)
+	i.current.Store(newIndex(entries))
+	return i
+}
+
+func labels(entries []Entry) []string {
+	result := []string{}
+	for _, entry := range entries {
+		result = append(result, entry.Label)
+	}
+	return result
+}
+
+func TestLookup(t *testing.T) {
+	i := testIndex([]Entry{
+		{Kind: Chip, ID: "1", Label: "Dill", Detail: "Estrella", weight: 5},
+		{Kind: Chip, ID: "2", Label: "Sourcream & Onion", Detail: "Estrella", weight: 50},
+		{Kind: Chip, ID: "3", Label: "Grillchips", Detail: "OLW", weight: 100},
+		{Kind: Chip, ID: "4", Label: "Dillchips", Detail: "OLW", weight: 5},
+		{Kind: Brand, ID: "estrella", Label: "Estrella", weight: 30},
+		{Kind: User, ID: "1", Label: "dillfan", Detail: "Anna Svensson", weight: 2},
+	})
+	tests := []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		// Labels starting with the prefix first, then by weight and label
+		{"dill", 10, []string{"Dill", "Dillchips", "dillfan"}},
+		{"estr", 10, []string{"Estrella", "Sourcream & Onion", "Dill"}},
+		{"ESTR", 10, []string{"Estrella", "Sourcream & Onion", "Dill"}},
+		{"o", 10, []string{"Grillchips", "Sourcream & Onion", "Dillchips"}},
+		// Every word has to match
+		{"estrella dill", 10, []string{"Dill"}},
+		{"dill olw", 10, []string{"Dillchips"}},
+		{"  dill,  olw ", 10, []string{"Dillchips"}},
+		// Words of the detail match
+		{"svens", 10, []string{"dillfan"}},
+		{"estr", 2, []string{"Estrella", "Sourcream & Onion"}},
+		{"dill", 1, []string{"Dill"}},
+		{"chips", 10, []string{}},
+		{"x", 10, []string{}},
+		{"", 10, []string{}},
+		{" - ", 10, []string{}},
+		{"dill", 0, []string{}},
+	}
+	for _, test := range tests {
+		got := labels(i.Lookup(test.prefix, test.limit))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Lookup(%q, %d) = %q, want %q", test.prefix, test.limit, got, test.want)
+		}
+	}
+}
+
+func TestLookupNil(t *testing.T) {
+	var i *Index
+	if got := i.Lookup("dill", 10); got != nil {
+		t.Errorf("Lookup on nil index = %v, want nil", got)
+	}
+	i.Invalidate()
+}
+
+// largeIndex has n chips named from a few common words, so that short
+// prefixes match a large part of it
+func largeIndex(n int) *Index {
+	flavors := []string{"salt", "dill", "sourcream", "onion", "sweet", "chili", "paprika", "grill", "ost", "vinäger"}
+	brands := []string{"Estrella", "OLW", "Lays", "Pringles", "Kettle", "Svenska Lantchips"}
+	entries := make([]Entry, n)
+	for j := range entries {
+		entries[j] = Entry{
+			Kind:   Chip,
+			ID:     fmt.Sprint(j),
+			Label:  fmt.Sprintf("%s & %s %d", flavors[j%len(flavors)], flavors[j/len(flavors)%len(flavors)], j),
+			Detail: brands[j%len(brands)],
+			weight: j % 1000,
+		}
+	}
+	return testIndex(entries)
+}
+
+var latencyPrefixes = []string{"s", "sa", "salt", "salt d", "estrella s", "12345", "x"}
+
+// Suggestions are requested on every keystroke and should be answered in
+// under 5 ms, also when a short prefix matches most of the index
+func TestLookupLatency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test")
+	}
+	i := largeIndex(20_000)
+	const runs = 20
+	for _, prefix := range latencyPrefixes {
+		start := time.Now()
+		for run := 0; run < runs; run++ {
+			i.Lookup(prefix, 20)
+		}
+		if elapsed := time.Since(start) / runs; elapsed > 5*time.Millisecond {
+			t.Errorf("Lookup(%q) took %v, want under 5ms", prefix, elapsed)
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	i := largeIndex(20_000)
+	for _, prefix := range latencyPrefixes {
+		b.Run(prefix, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				i.Lookup(prefix, 20)
+			}
+		})
+	}
+}